@@ -0,0 +1,117 @@
+// Package gateway holds the Gateway and HTTPRoute of the Gateway API. Only
+// the fields used to route the hostnames are decoded.
+package gateway
+
+import (
+	"net"
+
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// Group of the Gateway API
+const Group = "gateway.networking.k8s.io"
+
+// GroupVersions serving the resources, the first one served is watched
+var GroupVersions = []string{Group + "/v1", Group + "/v1beta1"}
+
+// Types of GatewayAddress
+const (
+	AddressIPAddress = "IPAddress"
+	AddressHostname  = "Hostname"
+)
+
+type Gateway struct {
+	unversioned.TypeMeta `json:",inline"`
+	v1.ObjectMeta        `json:"metadata,omitempty"`
+	Status               GatewayStatus `json:"status,omitempty"`
+}
+
+type GatewayStatus struct {
+	// Addresses are the addresses the gateway is reachable at, assigned by
+	// its implementation (i.e. the load balancer of its service)
+	Addresses []GatewayAddress `json:"addresses,omitempty"`
+}
+
+type GatewayAddress struct {
+	// Type is IPAddress when nil
+	Type  *string `json:"type,omitempty"`
+	Value string  `json:"value"`
+}
+
+type GatewayList struct {
+	unversioned.TypeMeta `json:",inline"`
+	unversioned.ListMeta `json:"metadata,omitempty"`
+	Items                []Gateway `json:"items"`
+}
+
+type HTTPRoute struct {
+	unversioned.TypeMeta `json:",inline"`
+	v1.ObjectMeta        `json:"metadata,omitempty"`
+	Spec                 HTTPRouteSpec `json:"spec,omitempty"`
+}
+
+type HTTPRouteSpec struct {
+	ParentRefs []ParentReference `json:"parentRefs,omitempty"`
+	Hostnames  []string          `json:"hostnames,omitempty"`
+}
+
+// ParentReference attaches a route to a gateway, Group and Kind default
+// to Gateway, Namespace to the one of the route
+type ParentReference struct {
+	Group     *string `json:"group,omitempty"`
+	Kind      *string `json:"kind,omitempty"`
+	Namespace *string `json:"namespace,omitempty"`
+	Name      string  `json:"name"`
+}
+
+type HTTPRouteList struct {
+	unversioned.TypeMeta `json:",inline"`
+	unversioned.ListMeta `json:"metadata,omitempty"`
+	Items                []HTTPRoute `json:"items"`
+}
+
+// IPs returns the IPv4 addresses of the gateway, the IPv6 ones cannot be
+// published in A records
+func (g *Gateway) IPs() []string {
+	ips := make([]string, 0, len(g.Status.Addresses))
+	for _, address := range g.Status.Addresses {
+		if address.Type != nil && *address.Type != AddressIPAddress {
+			continue
+		}
+		if ip := net.ParseIP(address.Value); ip != nil && ip.To4() != nil {
+			ips = append(ips, address.Value)
+		}
+	}
+	return ips
+}
+
+// Hostname returns the first hostname address of the gateway
+func (g *Gateway) Hostname() string {
+	for _, address := range g.Status.Addresses {
+		if address.Type != nil && *address.Type == AddressHostname {
+			return address.Value
+		}
+	}
+	return ""
+}
+
+// Gateways returns the namespace/name of the gateways the route is
+// attached to
+func (r *HTTPRoute) Gateways() []string {
+	gateways := make([]string, 0, len(r.Spec.ParentRefs))
+	for _, ref := range r.Spec.ParentRefs {
+		if ref.Group != nil && *ref.Group != Group {
+			continue
+		}
+		if ref.Kind != nil && *ref.Kind != "Gateway" {
+			continue
+		}
+		namespace := r.Namespace
+		if ref.Namespace != nil {
+			namespace = *ref.Namespace
+		}
+		gateways = append(gateways, namespace+"/"+ref.Name)
+	}
+	return gateways
+}
@@ -0,0 +1,86 @@
+// Package networking holds the networking.k8s.io/v1 Ingress and
+// IngressClass, which client-go does not know about. Only the fields used
+// to route the hostnames are decoded.
+package networking
+
+import (
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
+)
+
+const (
+	// GroupVersion is the API serving Ingress and IngressClass since
+	// kubernetes 1.19
+	GroupVersion = "networking.k8s.io/v1"
+	// ClassAnnotation is the deprecated way of choosing the class of an
+	// ingress, it is used when spec.ingressClassName is not set
+	ClassAnnotation = "kubernetes.io/ingress.class"
+	// DefaultClassAnnotation marks the IngressClass of the ingresses not
+	// choosing one
+	DefaultClassAnnotation = "ingressclass.kubernetes.io/is-default-class"
+)
+
+type Ingress struct {
+	unversioned.TypeMeta `json:",inline"`
+	v1.ObjectMeta        `json:"metadata,omitempty"`
+	Spec                 IngressSpec `json:"spec,omitempty"`
+}
+
+type IngressSpec struct {
+	IngressClassName *string       `json:"ingressClassName,omitempty"`
+	Rules            []IngressRule `json:"rules,omitempty"`
+}
+
+type IngressRule struct {
+	Host string `json:"host,omitempty"`
+}
+
+type IngressList struct {
+	unversioned.TypeMeta `json:",inline"`
+	unversioned.ListMeta `json:"metadata,omitempty"`
+	Items                []Ingress `json:"items"`
+}
+
+type IngressClass struct {
+	unversioned.TypeMeta `json:",inline"`
+	v1.ObjectMeta        `json:"metadata,omitempty"`
+	Spec                 IngressClassSpec `json:"spec,omitempty"`
+}
+
+type IngressClassSpec struct {
+	// Controller names the controller implementing the class (i.e.
+	// traefik.io/ingress-controller)
+	Controller string `json:"controller,omitempty"`
+}
+
+type IngressClassList struct {
+	unversioned.TypeMeta `json:",inline"`
+	unversioned.ListMeta `json:"metadata,omitempty"`
+	Items                []IngressClass `json:"items"`
+}
+
+// Class returns the class chosen by the ingress, spec.ingressClassName or
+// else the kubernetes.io/ingress.class annotation. It is empty when the
+// ingress uses the default class.
+func (i *Ingress) Class() string {
+	if i.Spec.IngressClassName != nil && *i.Spec.IngressClassName != "" {
+		return *i.Spec.IngressClassName
+	}
+	return i.Annotations[ClassAnnotation]
+}
+
+// IsDefault tells if the class is the one of the ingresses not choosing one
+func (c *IngressClass) IsDefault() bool {
+	return c.Annotations[DefaultClassAnnotation] == "true"
+}
+
+// FromExtensions converts an extensions/v1beta1 Ingress, served by the
+// clusters older than 1.19
+func FromExtensions(ingress *v1beta1.Ingress) *Ingress {
+	converted := &Ingress{ObjectMeta: ingress.ObjectMeta}
+	for _, rule := range ingress.Spec.Rules {
+		converted.Spec.Rules = append(converted.Spec.Rules, IngressRule{Host: rule.Host})
+	}
+	return converted
+}
@@ -0,0 +1,76 @@
+package traefik
+
+import (
+	"regexp"
+	"strings"
+)
+
+// matchers are the regular expressions of the matchers of hostnames by
+// name, the first group is set when the matcher is negated and the second
+// one holds its arguments
+var matchers = map[string]*regexp.Regexp{
+	"Host":    matcherRegexp("Host"),
+	"HostSNI": matcherRegexp("HostSNI"),
+}
+
+func matcherRegexp(matcher string) *regexp.Regexp {
+	return regexp.MustCompile(`(!\s*)?\b` + regexp.QuoteMeta(matcher) + `\s*\(([^)]*)\)`)
+}
+
+// MatchHostnames parses the hostnames of the matcher (Host or HostSNI) out
+// of a rule, i.e. Host(`a.example.com`, `b.example.com`) && Path(`/`).
+// Negated matchers and the catch-all HostSNI(`*`) are skipped.
+func MatchHostnames(rule, matcher string) []string {
+	hostnames := make([]string, 0, 1)
+	re, ok := matchers[matcher]
+	if !ok {
+		return hostnames
+	}
+	for _, match := range re.FindAllStringSubmatch(rule, -1) {
+		if match[1] != "" {
+			continue
+		}
+		for _, arg := range strings.Split(match[2], ",") {
+			hostname := strings.ToLower(strings.Trim(strings.TrimSpace(arg), "`\""))
+			if hostname == "" || strings.ContainsAny(hostname, "*{") {
+				continue
+			}
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	return hostnames
+}
+
+// FrontendRuleAnnotation holds the Traefik v1 rule of an ingress (i.e.
+// Host:a.example.com,b.example.com;PathPrefix:/api)
+const FrontendRuleAnnotation = "traefik.frontend.rule"
+
+// FrontendRuleHostnames parses the hostnames of the Host and HostRegexp
+// matchers out of a Traefik v1 rule. The regular expressions and wildcards
+// are returned as skipped, they cannot be published as records.
+func FrontendRuleHostnames(rule string) (hostnames, skipped []string) {
+	hostnames = make([]string, 0, 1)
+	for _, matcher := range strings.Split(rule, ";") {
+		parts := strings.SplitN(matcher, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.TrimSpace(parts[0])
+		isRegexp := strings.EqualFold(name, "HostRegexp")
+		if !isRegexp && !strings.EqualFold(name, "Host") {
+			continue
+		}
+		for _, arg := range strings.Split(parts[1], ",") {
+			hostname := strings.ToLower(strings.TrimSpace(arg))
+			if hostname == "" {
+				continue
+			}
+			if isRegexp || strings.ContainsAny(hostname, "*{") {
+				skipped = append(skipped, hostname)
+				continue
+			}
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	return hostnames, skipped
+}
@@ -0,0 +1,63 @@
+package traefik
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchHostnames(t *testing.T) {
+	tests := []struct {
+		rule    string
+		matcher string
+		want    []string
+	}{
+		{"Host(`a.example.com`)", "Host", []string{"a.example.com"}},
+		{"Host(`a.example.com`, `b.example.com`) && Path(`/`)", "Host", []string{"a.example.com", "b.example.com"}},
+		{"Host(`A.Example.com`)", "Host", []string{"a.example.com"}},
+		{"Host(\"a.example.com\", `b.example.com`)", "Host", []string{"a.example.com", "b.example.com"}},
+		{"!Host(`a.example.com`) && Host(`b.example.com`)", "Host", []string{"b.example.com"}},
+		{"! Host(`a.example.com`)", "Host", []string{}},
+		{"(Host(`a.example.com`) || Host(`b.example.com`)) && PathPrefix(`/x`)", "Host", []string{"a.example.com", "b.example.com"}},
+		{"Host(`a.example.com`)&&Host(`b.example.com`)", "Host", []string{"a.example.com", "b.example.com"}},
+		{"HostRegexp(`{sub:[a-z]+}.example.com`)", "Host", []string{}},
+		{"Host(`*.example.com`)", "Host", []string{}},
+		{"Host(``)", "Host", []string{}},
+		{"HostSNI(`db.example.com`)", "HostSNI", []string{"db.example.com"}},
+		{"HostSNI(`*`)", "HostSNI", []string{}},
+		{"HostSNI(`db.example.com`)", "Host", []string{}},
+		{"Host(`a.example.com`)", "HostSNI", []string{}},
+		{"Path(`/`)", "Path", []string{}},
+	}
+	for _, test := range tests {
+		hostnames := MatchHostnames(test.rule, test.matcher)
+		if !reflect.DeepEqual(hostnames, test.want) {
+			t.Errorf("MatchHostnames(%q, %q) = %v, want %v", test.rule, test.matcher, hostnames, test.want)
+		}
+	}
+}
+
+func TestFrontendRuleHostnames(t *testing.T) {
+	tests := []struct {
+		rule      string
+		hostnames []string
+		skipped   []string
+	}{
+		{"Host:a.example.com", []string{"a.example.com"}, nil},
+		{"Host:a.example.com,b.example.com", []string{"a.example.com", "b.example.com"}, nil},
+		{"Host: A.example.com , b.example.com", []string{"a.example.com", "b.example.com"}, nil},
+		{"Host:a.example.com;PathPrefix:/x", []string{"a.example.com"}, nil},
+		{"PathPrefix:/x;host:a.example.com", []string{"a.example.com"}, nil},
+		{"HostRegexp:{sub:[a-z]+}.example.com", []string{}, []string{"{sub:[a-z]+}.example.com"}},
+		{"Host:*.example.com,b.example.com", []string{"b.example.com"}, []string{"*.example.com"}},
+		{"Host:a.example.com,,;;Host:", []string{"a.example.com"}, nil},
+		{"PathPrefix:/x", []string{}, nil},
+		{"", []string{}, nil},
+	}
+	for _, test := range tests {
+		hostnames, skipped := FrontendRuleHostnames(test.rule)
+		if !reflect.DeepEqual(hostnames, test.hostnames) || !reflect.DeepEqual(skipped, test.skipped) {
+			t.Errorf("FrontendRuleHostnames(%q) = %v, %v, want %v, %v",
+				test.rule, hostnames, skipped, test.hostnames, test.skipped)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Package traefik holds the IngressRoute and IngressRouteTCP custom
+// resources of Traefik. Only the fields used to route the hostnames are
+// decoded.
+package traefik
+
+import (
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// GroupVersions serving the custom resources, traefik.containo.us was
+// replaced by traefik.io in Traefik 2.10
+var GroupVersions = []string{"traefik.io/v1alpha1", "traefik.containo.us/v1alpha1"}
+
+type IngressRoute struct {
+	unversioned.TypeMeta `json:",inline"`
+	v1.ObjectMeta        `json:"metadata,omitempty"`
+	Spec                 IngressRouteSpec `json:"spec,omitempty"`
+}
+
+// IngressRouteSpec is the spec of both IngressRoute and IngressRouteTCP
+type IngressRouteSpec struct {
+	// EntryPoints are the Traefik entry points serving the routes, every
+	// default entry point when empty
+	EntryPoints []string `json:"entryPoints,omitempty"`
+	Routes      []Route  `json:"routes,omitempty"`
+}
+
+type Route struct {
+	// Match is the rule of the route (i.e. Host(`a.example.com`) &&
+	// PathPrefix(`/api`))
+	Match string `json:"match"`
+}
+
+type IngressRouteList struct {
+	unversioned.TypeMeta `json:",inline"`
+	unversioned.ListMeta `json:"metadata,omitempty"`
+	Items                []IngressRoute `json:"items"`
+}
+
+type IngressRouteTCP struct {
+	unversioned.TypeMeta `json:",inline"`
+	v1.ObjectMeta        `json:"metadata,omitempty"`
+	Spec                 IngressRouteSpec `json:"spec,omitempty"`
+}
+
+type IngressRouteTCPList struct {
+	unversioned.TypeMeta `json:",inline"`
+	unversioned.ListMeta `json:"metadata,omitempty"`
+	Items                []IngressRouteTCP `json:"items"`
+}
+
+// Hostnames returns the hostnames matched by the routes, Host matchers
+func (r *IngressRoute) Hostnames() []string {
+	return r.Spec.hostnames("Host")
+}
+
+// Hostnames returns the hostnames matched by the routes, HostSNI matchers
+func (r *IngressRouteTCP) Hostnames() []string {
+	return r.Spec.hostnames("HostSNI")
+}
+
+func (s IngressRouteSpec) hostnames(matcher string) []string {
+	hostnames := make([]string, 0, len(s.Routes))
+	seen := make(map[string]bool)
+	for _, route := range s.Routes {
+		for _, hostname := range MatchHostnames(route.Match, matcher) {
+			if !seen[hostname] {
+				seen[hostname] = true
+				hostnames = append(hostnames, hostname)
+			}
+		}
+	}
+	return hostnames
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/victor-fdez/kube-route53-traefik/dns_providers"
+)
+
+// route53-webhook is the reference sidecar of the webhook provider, it
+// serves the webhook provider protocol on top of the in-process route53
+// provider.
+func main() {
+	listenAddress := flag.String("listen-address", "localhost:8888", "address the webhook provider protocol is served on")
+	isDev := flag.Bool("is-dev", false, "log output to console if in development mode")
+	flag.Parse()
+	var log *zap.Logger
+	var err error
+	if *isDev {
+		log, err = zap.NewDevelopment()
+	} else {
+		log, err = zap.NewProduction()
+	}
+	if err != nil {
+		panic(err)
+	}
+	sLog := log.Sugar()
+	provider, err := dns_providers.NewProvider(dns_providers.Config{Provider: "aws"})
+	if err != nil {
+		sLog.Panic(err)
+	}
+	sLog.Infof("Serving route53 through the webhook provider protocol on %s", *listenAddress)
+	sLog.Panic(http.ListenAndServe(*listenAddress, dns_providers.NewWebhookHandler(provider)))
+}
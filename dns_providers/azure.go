@@ -0,0 +1,427 @@
+package dns_providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const azureEndpoint = "https://management.azure.com"
+const azureAPIVersion = "2018-05-01"
+
+func init() {
+	registerProvider("azure", newAzureProvider)
+}
+
+// azureProvider is the DNSProvider storing records in Azure DNS zones of
+// one or more resource groups. Azure has no alias records pointing to a
+// load balancer hostname, so alias routes are published as CNAMEs. Azure
+// has no batch API either, changes are applied one by one and when one
+// fails the record sets already changed are restored.
+type azureProvider struct {
+	client         *http.Client
+	endpoint       string
+	subscriptionID string
+	resourceGroups []string
+}
+
+type azureZone struct {
+	Name string `json:"name"`
+}
+
+type azureZones struct {
+	Value    []azureZone `json:"value"`
+	NextLink string      `json:"nextLink"`
+}
+
+type azureARecord struct {
+	IPv4Address string `json:"ipv4Address"`
+}
+
+type azureAAAARecord struct {
+	IPv6Address string `json:"ipv6Address"`
+}
+
+type azureCNAMERecord struct {
+	CNAME string `json:"cname"`
+}
+
+type azureTXTRecord struct {
+	Value []string `json:"value"`
+}
+
+type azureRecordSetProperties struct {
+	TTL         int64             `json:"TTL"`
+	ARecords    []azureARecord    `json:"ARecords,omitempty"`
+	AAAARecords []azureAAAARecord `json:"AAAARecords,omitempty"`
+	CNAMERecord *azureCNAMERecord `json:"CNAMERecord,omitempty"`
+	TXTRecords  []azureTXTRecord  `json:"TXTRecords,omitempty"`
+}
+
+type azureRecordSet struct {
+	Name       string                   `json:"name,omitempty"`
+	Type       string                   `json:"type,omitempty"`
+	Properties azureRecordSetProperties `json:"properties"`
+}
+
+type azureRecordSets struct {
+	Value    []azureRecordSet `json:"value"`
+	NextLink string           `json:"nextLink"`
+}
+
+// azureError is an error response of the ARM endpoint
+type azureError struct {
+	method string
+	url    string
+	status string
+	code   int
+	msg    string
+}
+
+func (e *azureError) Error() string {
+	return fmt.Sprintf("%s %s returned %s: %s", e.method, e.url, e.status, e.msg)
+}
+
+// newAzureProvider creates the azure provider, the service principal used
+// to authenticate is read from the AZURE_TENANT_ID, AZURE_CLIENT_ID and
+// AZURE_CLIENT_SECRET environment variables.
+func newAzureProvider(config Config) (DNSProvider, error) {
+	if config.AzureSubscriptionID == "" || len(config.AzureResourceGroups) == 0 {
+		return nil, fmt.Errorf("a subscription and at least one resource group are needed for the azure provider")
+	}
+	p := &azureProvider{
+		client:         http.DefaultClient,
+		endpoint:       azureEndpoint,
+		subscriptionID: config.AzureSubscriptionID,
+		resourceGroups: config.AzureResourceGroups,
+	}
+	// a custom endpoint is used to talk to a fake ARM endpoint which does
+	// not need any credentials
+	if config.AzureEndpoint != "" {
+		p.endpoint = strings.TrimSuffix(config.AzureEndpoint, "/")
+		return p, nil
+	}
+	tenantID := os.Getenv("AZURE_TENANT_ID")
+	if tenantID == "" {
+		return nil, fmt.Errorf("AZURE_TENANT_ID is needed for the azure provider")
+	}
+	credentials := clientcredentials.Config{
+		ClientID:       os.Getenv("AZURE_CLIENT_ID"),
+		ClientSecret:   os.Getenv("AZURE_CLIENT_SECRET"),
+		TokenURL:       "https://login.microsoftonline.com/" + tenantID + "/oauth2/token",
+		EndpointParams: url.Values{"resource": {azureEndpoint + "/"}},
+	}
+	p.client = credentials.Client(context.Background())
+	return p, nil
+}
+
+func (p *azureProvider) Zones() ([]Zone, error) {
+	zones := make([]Zone, 0, 1)
+	for _, group := range p.resourceGroups {
+		next := p.groupURL(group) + "/providers/Microsoft.Network/dnsZones?api-version=" + azureAPIVersion
+		for next != "" {
+			var out azureZones
+			if err := p.do("GET", next, &out); err != nil {
+				return nil, fmt.Errorf("Failed to list zones in resource group %s: %v", group, err)
+			}
+			for _, zone := range out.Value {
+				zones = append(zones, Zone{
+					ID:   group + "/" + zone.Name,
+					Name: normalizeName(zone.Name),
+				})
+			}
+			next = out.NextLink
+		}
+	}
+	return zones, nil
+}
+
+func (p *azureProvider) Records(zone Zone) ([]Record, error) {
+	records := make([]Record, 0, 1)
+	next := p.zoneURL(zone) + "/recordsets?api-version=" + azureAPIVersion
+	for next != "" {
+		var out azureRecordSets
+		if err := p.do("GET", next, &out); err != nil {
+			return nil, fmt.Errorf("Failed to list record sets in zone %s: %v", zone.ID, err)
+		}
+		for _, rrset := range out.Value {
+			records = append(records, fromAzureRecordSet(zone, rrset))
+		}
+		next = out.NextLink
+	}
+	return records, nil
+}
+
+func (p *azureProvider) ApplyChanges(zone Zone, changes []Change) error {
+	tx := &azureTx{p: p}
+	for _, change := range changes {
+		if err := tx.apply(zone, change); err != nil {
+			if rollbackErr := tx.rollback(); rollbackErr != nil {
+				return fmt.Errorf("%v, and failed to undo the changes already applied: %v", err, rollbackErr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// azureTx applies the changes of a single ApplyChanges call, keeping the
+// record sets it changes to restore them on failure
+type azureTx struct {
+	p    *azureProvider
+	undo []func() error
+}
+
+func (tx *azureTx) apply(zone Zone, change Change) error {
+	recordType, rrset := toAzureRecordSet(change.Record)
+	name := azureRelativeName(zone, change.Record.Name)
+	switch change.Action {
+	case ActionCreate, ActionUpsert:
+		// a name can either have a CNAME or A records, remove the one
+		// we are not about to write
+		if recordType == "A" || recordType == "CNAME" {
+			other := "CNAME"
+			if recordType == "CNAME" {
+				other = "A"
+			}
+			if err := tx.delete(tx.p.recordURL(zone, other, name)); err != nil {
+				return fmt.Errorf("Failed to delete %s record %s: %v", other, change.Record.Name, err)
+			}
+		}
+		// CREATE only writes the record set if it does not exist yet
+		err := tx.put(tx.p.recordURL(zone, recordType, name), change.Action == ActionCreate, rrset)
+		if err != nil {
+			return fmt.Errorf("Failed to update %s record %s: %v", recordType, change.Record.Name, err)
+		}
+	case ActionDelete:
+		// like route53 only a record set with exactly the values given is
+		// deleted
+		u := tx.p.recordURL(zone, recordType, name)
+		old, err := tx.save(u)
+		if err != nil {
+			return fmt.Errorf("Failed to delete %s record %s: %v", recordType, change.Record.Name, err)
+		}
+		if old == nil {
+			return fmt.Errorf("Tried to delete %s record %s but it was not found", recordType, change.Record.Name)
+		}
+		if !azureMatches(zone, *old, change.Record) {
+			return fmt.Errorf("Tried to delete %s record %s but the values provided do not match the current values", recordType, change.Record.Name)
+		}
+		if err := tx.p.do("DELETE", u, nil); err != nil {
+			return fmt.Errorf("Failed to delete %s record %s: %v", recordType, change.Record.Name, err)
+		}
+	default:
+		return fmt.Errorf("Unknown change action %s", change.Action)
+	}
+	return nil
+}
+
+func (tx *azureTx) put(u string, onlyCreate bool, rrset azureRecordSet) error {
+	if _, err := tx.save(u); err != nil {
+		return err
+	}
+	return tx.p.put(u, onlyCreate, rrset)
+}
+
+func (tx *azureTx) delete(u string) error {
+	if _, err := tx.save(u); err != nil {
+		return err
+	}
+	return tx.p.do("DELETE", u, nil)
+}
+
+// save reads the record set at u before it is changed, and keeps how to
+// put it back. It returns the record set read, nil when it does not exist.
+func (tx *azureTx) save(u string) (*azureRecordSet, error) {
+	old, err := tx.p.recordSet(u)
+	if err != nil {
+		return nil, err
+	}
+	tx.undo = append(tx.undo, func() error {
+		if old == nil {
+			return tx.p.do("DELETE", u, nil)
+		}
+		return tx.p.put(u, false, azureRecordSet{Properties: old.Properties})
+	})
+	return old, nil
+}
+
+// rollback restores the record sets changed in reverse order, it keeps
+// going on errors and returns the first one
+func (tx *azureTx) rollback() error {
+	var first error
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		if err := tx.undo[i](); err != nil && first == nil {
+			first = err
+		}
+	}
+	tx.undo = nil
+	return first
+}
+
+// recordSet returns the record set at u, nil when it does not exist
+func (p *azureProvider) recordSet(u string) (*azureRecordSet, error) {
+	var rrset azureRecordSet
+	err := p.do("GET", u, &rrset)
+	if azureErr, ok := err.(*azureError); ok && azureErr.code == http.StatusNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &rrset, nil
+}
+
+func (p *azureProvider) groupURL(group string) string {
+	return p.endpoint + "/subscriptions/" + url.PathEscape(p.subscriptionID) +
+		"/resourceGroups/" + url.PathEscape(group)
+}
+
+func (p *azureProvider) zoneURL(zone Zone) string {
+	parts := strings.SplitN(zone.ID, "/", 2)
+	return p.groupURL(parts[0]) + "/providers/Microsoft.Network/dnsZones/" + url.PathEscape(parts[1])
+}
+
+func (p *azureProvider) recordURL(zone Zone, recordType, name string) string {
+	return p.zoneURL(zone) + "/" + recordType + "/" + url.PathEscape(name) + "?api-version=" + azureAPIVersion
+}
+
+func (p *azureProvider) put(u string, onlyCreate bool, rrset azureRecordSet) error {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(rrset); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", u, &body)
+	if err != nil {
+		return err
+	}
+	if onlyCreate {
+		req.Header.Set("If-None-Match", "*")
+	}
+	return p.send(req, nil)
+}
+
+// do sends a request to the ARM endpoint, and decodes the JSON response
+// into out
+func (p *azureProvider) do(method, u string, out interface{}) error {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	return p.send(req, out)
+}
+
+func (p *azureProvider) send(req *http.Request, out interface{}) error {
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return &azureError{
+			method: req.Method,
+			url:    req.URL.String(),
+			status: resp.Status,
+			code:   resp.StatusCode,
+			msg:    strings.TrimSpace(string(msg)),
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// azureRelativeName returns the name of a record relative to its zone,
+// which is @ for the zone apex
+func azureRelativeName(zone Zone, name string) string {
+	name = normalizeName(name)
+	zoneName := normalizeName(zone.Name)
+	if name == zoneName {
+		return "@"
+	}
+	return strings.TrimSuffix(name, "."+zoneName)
+}
+
+func toAzureRecordSet(record Record) (string, azureRecordSet) {
+	rrset := azureRecordSet{
+		Properties: azureRecordSetProperties{TTL: record.TTL},
+	}
+	if rrset.Properties.TTL == 0 {
+		rrset.Properties.TTL = 300
+	}
+	// Azure has no alias records, point a CNAME to the load balancer
+	if record.Alias != "" {
+		rrset.Properties.CNAMERecord = &azureCNAMERecord{CNAME: record.Alias}
+		return "CNAME", rrset
+	}
+	switch record.Type {
+	case "A":
+		for _, ip := range record.Targets {
+			rrset.Properties.ARecords = append(rrset.Properties.ARecords, azureARecord{IPv4Address: ip})
+		}
+	case "AAAA":
+		for _, ip := range record.Targets {
+			rrset.Properties.AAAARecords = append(rrset.Properties.AAAARecords, azureAAAARecord{IPv6Address: ip})
+		}
+	case "CNAME":
+		if len(record.Targets) != 0 {
+			rrset.Properties.CNAMERecord = &azureCNAMERecord{CNAME: record.Targets[0]}
+		}
+	case "TXT":
+		for _, value := range record.Targets {
+			rrset.Properties.TXTRecords = append(rrset.Properties.TXTRecords, azureTXTRecord{
+				Value: []string{strings.Trim(value, `"`)},
+			})
+		}
+	}
+	return record.Type, rrset
+}
+
+// azureMatches tells if the stored record set has exactly the values of
+// record
+func azureMatches(zone Zone, stored azureRecordSet, record Record) bool {
+	recordType, rrset := toAzureRecordSet(record)
+	rrset.Name = stored.Name
+	rrset.Type = "Microsoft.Network/dnszones/" + recordType
+	current, wanted := fromAzureRecordSet(zone, stored), fromAzureRecordSet(zone, rrset)
+	if recordType == "CNAME" {
+		// the hostnames may or may not be fully qualified
+		current.Targets = []string{strings.TrimSuffix(strings.Join(current.Targets, ""), ".")}
+		wanted.Targets = []string{strings.TrimSuffix(strings.Join(wanted.Targets, ""), ".")}
+	}
+	return recordsEqual(current, wanted)
+}
+
+func fromAzureRecordSet(zone Zone, rrset azureRecordSet) Record {
+	record := Record{
+		Name: normalizeName(zone.Name),
+		Type: rrset.Type[strings.LastIndex(rrset.Type, "/")+1:],
+		TTL:  rrset.Properties.TTL,
+	}
+	if rrset.Name != "@" {
+		record.Name = rrset.Name + "." + record.Name
+	}
+	for _, a := range rrset.Properties.ARecords {
+		record.Targets = append(record.Targets, a.IPv4Address)
+	}
+	for _, aaaa := range rrset.Properties.AAAARecords {
+		record.Targets = append(record.Targets, aaaa.IPv6Address)
+	}
+	if rrset.Properties.CNAMERecord != nil {
+		record.Targets = append(record.Targets, rrset.Properties.CNAMERecord.CNAME)
+	}
+	for _, txt := range rrset.Properties.TXTRecords {
+		record.Targets = append(record.Targets, `"`+strings.Join(txt.Value, "")+`"`)
+	}
+	return record
+}
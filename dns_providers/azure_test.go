@@ -0,0 +1,148 @@
+package dns_providers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeARM is a fake ARM endpoint serving the example.com zone of the rg
+// resource group
+type fakeARM struct {
+	sync.Mutex
+	rrsets map[string]azureRecordSet
+}
+
+func (f *fakeARM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	const group = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/dnsZones"
+	const zone = group + "/example.com"
+	if r.URL.Query().Get("api-version") != azureAPIVersion {
+		http.Error(w, "missing api-version", http.StatusBadRequest)
+		return
+	}
+	switch {
+	case r.Method == "GET" && r.URL.Path == group:
+		json.NewEncoder(w).Encode(azureZones{Value: []azureZone{{Name: "example.com"}}})
+		return
+	case r.Method == "GET" && r.URL.Path == zone+"/recordsets":
+		var out azureRecordSets
+		for _, rrset := range f.rrsets {
+			out.Value = append(out.Value, rrset)
+		}
+		sort.Slice(out.Value, func(i, j int) bool { return out.Value[i].Name < out.Value[j].Name })
+		json.NewEncoder(w).Encode(out)
+		return
+	case !strings.HasPrefix(r.URL.Path, zone+"/"):
+		http.NotFound(w, r)
+		return
+	}
+	// the record set URLs are <zone>/<type>/<name>
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, zone+"/"), "/", 2)
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	key := parts[0] + "/" + parts[1]
+	rrset, exists := f.rrsets[key]
+	switch r.Method {
+	case "GET":
+		if !exists {
+			http.Error(w, `{"error":{"code":"NotFound"}}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(rrset)
+	case "PUT":
+		if exists && r.Header.Get("If-None-Match") == "*" {
+			http.Error(w, `{"error":{"code":"PreconditionFailed"}}`, http.StatusPreconditionFailed)
+			return
+		}
+		var in azureRecordSet
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		in.Name = parts[1]
+		in.Type = "Microsoft.Network/dnszones/" + parts[0]
+		f.rrsets[key] = in
+		json.NewEncoder(w).Encode(in)
+	case "DELETE":
+		// ARM answers 204 when there was nothing to delete
+		delete(f.rrsets, key)
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeARM) keys() []string {
+	f.Lock()
+	defer f.Unlock()
+	keys := make([]string, 0, len(f.rrsets))
+	for key := range f.rrsets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func init() {
+	contractBackends["azure"] = func(t *testing.T) (DNSProvider, Zone, func()) {
+		provider, _, closeServer := newTestAzureProvider(t)
+		return provider, Zone{ID: "rg/example.com", Name: "example.com."}, closeServer
+	}
+}
+
+func newTestAzureProvider(t *testing.T, records ...Record) (DNSProvider, *fakeARM, func()) {
+	fake := &fakeARM{rrsets: make(map[string]azureRecordSet)}
+	zone := Zone{ID: "rg/example.com", Name: "example.com."}
+	for _, record := range records {
+		recordType, rrset := toAzureRecordSet(record)
+		rrset.Name = azureRelativeName(zone, record.Name)
+		rrset.Type = "Microsoft.Network/dnszones/" + recordType
+		fake.rrsets[recordType+"/"+rrset.Name] = rrset
+	}
+	server := httptest.NewServer(fake)
+	provider, err := newAzureProvider(Config{
+		AzureSubscriptionID: "sub",
+		AzureResourceGroups: []string{"rg"},
+		AzureEndpoint:       server.URL,
+	})
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return provider, fake, server.Close
+}
+
+func TestAzureDeleteChecksValues(t *testing.T) {
+	web := Record{Name: "web.example.com.", Type: "A", TTL: 300, Targets: []string{"10.0.0.1"}}
+	provider, fake, closeServer := newTestAzureProvider(t, web)
+	defer closeServer()
+	zone := Zone{ID: "rg/example.com", Name: "example.com."}
+	for _, record := range []Record{
+		{Name: web.Name, Type: "A", TTL: 300, Targets: []string{"10.0.0.2"}},
+		{Name: web.Name, Type: "A", TTL: 60, Targets: []string{"10.0.0.1"}},
+		{Name: web.Name, Type: "A", TTL: 300, Targets: []string{"10.0.0.1", "10.0.0.2"}},
+		{Name: web.Name, Type: "CNAME", TTL: 300, Targets: []string{"lb.example.net"}},
+	} {
+		if err := provider.ApplyChanges(zone, []Change{{Action: ActionDelete, Record: record}}); err == nil {
+			t.Errorf("deleting %v succeeded", record)
+		}
+	}
+	if got, want := fake.keys(), []string{"A/web"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("record sets = %v, want %v", got, want)
+	}
+	if err := provider.ApplyChanges(zone, []Change{{Action: ActionDelete, Record: web}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.keys(); len(got) != 0 {
+		t.Errorf("record sets left after delete: %v", got)
+	}
+}
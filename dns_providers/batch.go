@@ -0,0 +1,289 @@
+package dns_providers
+
+import (
+	"fmt"
+	"strings"
+
+	messagediff "gopkg.in/d4l3k/messagediff.v1"
+
+	"github.com/victor-fdez/kube-route53-traefik/metrics"
+)
+
+// Batch collects the route changes of a single event, Apply sends them
+// with as few ApplyChanges calls as possible, one per zone unless the
+// provider limits the size of a call.
+type Batch struct {
+	id string
+	// zones and records are listed once per batch
+	zones   []Zone
+	records map[string][]Record
+	// units are the changes of every zone in the order they were added
+	units map[string][]changeUnit
+	order []Zone
+	// pending are the routes stored once the batch is applied, nil for the
+	// removed ones
+	pending map[string]*Route
+}
+
+// changeUnit are the changes of a single route, they are always applied
+// in the same call
+type changeUnit struct {
+	changes []Change
+	key     string
+	route   *Route
+}
+
+// NewBatch creates an empty batch of changes to the routes owned by id
+func NewBatch(id string) *Batch {
+	return &Batch{
+		id:      id,
+		records: make(map[string][]Record),
+		units:   make(map[string][]changeUnit),
+		pending: make(map[string]*Route),
+	}
+}
+
+// AddRoute adds the creation or update of the records of subdomain to the
+// batch. Records which already exist are only updated when the id of the
+// batch owns them.
+func (b *Batch) AddRoute(subdomain string, ips []string, alias string, options RouteOptions) error {
+	key := b.id + "/" + subdomain
+	zone, err := b.zoneFor(subdomain)
+	if err != nil {
+		return fmt.Errorf("Unable to get hosted zone for %s: %v", subdomain, err)
+	}
+	route := Route{
+		subdomain: subdomain,
+		domain:    zone.Name,
+		zone:      *zone,
+		alias:     alias,
+		ips:       ips,
+		options:   options,
+	}
+	if old, ok := b.lookup(key); ok {
+		sLog.Infof("Found route in stored routes checking if something has changed (%s)", key)
+		// check if something changed for structure
+		diff, equal := messagediff.DeepDiff(route, *old)
+		if equal {
+			// do nothing we already have routes setup
+			return nil
+		}
+		sLog.Infof("Routes differed %v", diff)
+	} else {
+		if err := b.claimName(subdomain, *zone); err != nil {
+			return err
+		}
+		sLog.Infof("adding subdomain (%s) to domain (%s)", route.subdomain, route.domain)
+	}
+	if alias != "" {
+		sLog.Infof("UPSERT A Record in zone %s for domain %s with Alias [%s]", zone.ID, subdomain, alias)
+	} else {
+		sLog.Infof("UPSERT A Record in zone %s for domain %s with IP addresses %v", zone.ID, subdomain, ips)
+	}
+	b.add(route, key, false, []Change{
+		{Action: ActionUpsert, Record: newRecord(subdomain, ips, alias, options)},
+		{Action: ActionUpsert, Record: ownerRecord(subdomain, b.id, options.Resource)},
+	})
+	return nil
+}
+
+// RemoveRoute adds the deletion of the records of subdomain to the batch,
+// only the routes stored with the id of the batch can be removed
+func (b *Batch) RemoveRoute(subdomain string, alias string) error {
+	key := b.id + "/" + subdomain
+	route, ok := b.lookup(key)
+	if !ok {
+		// There's nothing to delete hmmm
+		return fmt.Errorf("Unable to delete any DNS routes since the route does not exists (%s)", key)
+	}
+	if route.alias != "" {
+		sLog.Infof("DELETE A Record in zone %s for domain %s with Alias [%s]", route.zone.ID, subdomain, route.alias)
+	} else {
+		sLog.Infof("DELETE A Record in zone %s for domain %s with IP addresses %v", route.zone.ID, subdomain, route.ips)
+	}
+	b.add(*route, key, true, []Change{
+		{Action: ActionDelete, Record: newRecord(subdomain, route.ips, route.alias, route.options)},
+		{Action: ActionDelete, Record: ownerRecord(subdomain, b.id, route.options.Resource)},
+	})
+	return nil
+}
+
+// Apply sends the changes of every zone to the provider, and stores the
+// routes whose changes were applied
+func (b *Batch) Apply() error {
+	var errs []string
+	for _, zone := range b.order {
+		units := b.units[zone.ID]
+		if dryRun {
+			sLog.Infof("DRY RUN: We normally would have applied %d route changes to %s", len(units), zone.ID)
+			storeUnits(units)
+			continue
+		}
+		applied, failed, err := applyUnits(zone, units)
+		storeUnits(applied)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("zone %s: %v", zone.ID, err))
+			// the changes are retried later instead of being lost
+			queueRetry(zone, failed, err)
+		}
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("Unable to update DNS: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// storeUnits stores the routes of the units applied
+func storeUnits(units []changeUnit) {
+	routesLock.Lock()
+	defer routesLock.Unlock()
+	for _, unit := range units {
+		if unit.route == nil {
+			// delete route from routes if successfully deleted from the provider
+			delete(routes, unit.key)
+		} else {
+			routes[unit.key] = *unit.route
+		}
+	}
+	metrics.Routes.Set(float64(len(routes)))
+}
+
+func (b *Batch) add(route Route, key string, removed bool, changes []Change) {
+	unit := changeUnit{changes: changes, key: key}
+	if !removed {
+		unit.route = &route
+	}
+	if _, ok := b.units[route.zone.ID]; !ok {
+		b.order = append(b.order, route.zone)
+	}
+	b.units[route.zone.ID] = append(b.units[route.zone.ID], unit)
+	b.pending[key] = unit.route
+	// older changes of the route waiting to be retried are superseded
+	dropRetries(func(zone Zone, queued changeUnit) bool { return queued.key == key })
+}
+
+// lookup returns the stored route of key as it will be once the batch is
+// applied
+func (b *Batch) lookup(key string) (*Route, bool) {
+	if route, ok := b.pending[key]; ok {
+		return route, route != nil
+	}
+	route, ok := routes[key]
+	return &route, ok
+}
+
+func (b *Batch) zoneFor(domain string) (*Zone, error) {
+	if _, err := getTLD(domain); err != nil {
+		return nil, err
+	}
+	if _, ok := provider.(ZoneFinder); !ok && b.zones == nil {
+		zones, err := listZones()
+		if err != nil {
+			return nil, err
+		}
+		b.zones = zones
+	}
+	return zoneFor(domain, b.zones)
+}
+
+// claimName makes sure subdomain can be written by the id of the batch
+func (b *Batch) claimName(subdomain string, zone Zone) error {
+	records, ok := b.records[zone.ID]
+	if !ok {
+		var err error
+		records, err = listRecords(zone)
+		if err != nil {
+			return err
+		}
+		b.records[zone.ID] = records
+	}
+	return claimName(b.id, subdomain, records)
+}
+
+// applyUnits applies units to zone with as few calls as the provider
+// accepts, and returns the units applied and the ones which failed with
+// the first error. When a call fails its units are applied one by one so
+// a single bad unit does not hold back the rest of the zone, the later
+// units of a name which failed are not applied as they may depend on it.
+func applyUnits(zone Zone, units []changeUnit) ([]changeUnit, []changeUnit, error) {
+	var limits BatchLimits
+	if limiter, ok := provider.(ChangeLimiter); ok {
+		limits = limiter.BatchLimits()
+	}
+	applied := make([]changeUnit, 0, len(units))
+	var failed []changeUnit
+	var firstErr error
+	failedKeys := make(map[string]bool)
+	fail := func(unit changeUnit, err error) {
+		failed = append(failed, unit)
+		failedKeys[unit.key] = true
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	for start := 0; start < len(units); {
+		if failedKeys[units[start].key] {
+			fail(units[start], nil)
+			start++
+			continue
+		}
+		end := start + 1
+		changes := append([]Change{}, units[start].changes...)
+		for ; end < len(units) && !failedKeys[units[end].key]; end++ {
+			next := append(changes[:len(changes):len(changes)], units[end].changes...)
+			if !limits.Fits(next) {
+				break
+			}
+			changes = next
+		}
+		err := applyChanges(zone, changes)
+		if err != nil && end-start > 1 {
+			sLog.Warnf("Failed to apply %d changes to zone %s at once, applying them one by one: %v", end-start, zone.ID, err)
+			for _, unit := range units[start:end] {
+				if failedKeys[unit.key] {
+					fail(unit, nil)
+				} else if err := applyChanges(zone, unit.changes); err != nil {
+					fail(unit, err)
+				} else {
+					countChanges(zone, unit.changes)
+					applied = append(applied, unit)
+				}
+			}
+		} else if err != nil {
+			fail(units[start], err)
+		} else {
+			countChanges(zone, changes)
+			applied = append(applied, units[start:end]...)
+		}
+		start = end
+	}
+	return applied, failed, firstErr
+}
+
+// countChanges counts the record changes applied to zone, the owner TXT
+// records are left out
+func countChanges(zone Zone, changes []Change) {
+	for _, change := range changes {
+		if change.Record.Type != "TXT" {
+			metrics.RecordChanges.WithLabelValues(zone.Name, change.Action).Inc()
+		}
+	}
+}
+
+// groupChanges splits changes into units, consecutive changes of the same
+// name and of its owner record belong to the same unit
+func groupChanges(changes []Change) []changeUnit {
+	units := make([]changeUnit, 0, len(changes))
+	for _, change := range changes {
+		key := normalizeName(change.Record.Name)
+		if name, ok := ownedName(key); ok {
+			key = name
+		}
+		if n := len(units); n != 0 && units[n-1].key == key {
+			units[n-1].changes = append(units[n-1].changes, change)
+			continue
+		}
+		units = append(units, changeUnit{changes: []Change{change}, key: key})
+	}
+	return units
+}
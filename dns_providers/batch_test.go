@@ -0,0 +1,81 @@
+package dns_providers
+
+import (
+	"fmt"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// failingProvider fails every call changing the records of name
+type failingProvider struct {
+	*MemoryProvider
+	name string
+}
+
+func (p failingProvider) ApplyChanges(zone Zone, changes []Change) error {
+	for _, change := range changes {
+		if normalizeName(change.Record.Name) == normalizeName(p.name) {
+			return fmt.Errorf("Failing change of %s", p.name)
+		}
+	}
+	return p.MemoryProvider.ApplyChanges(zone, changes)
+}
+
+func findRecord(t *testing.T, p *MemoryProvider, name, recordType string) (Record, bool) {
+	records, err := p.Records(Zone{Name: "example.com."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if record.Name == normalizeName(name) && record.Type == recordType {
+			return record, true
+		}
+	}
+	return Record{}, false
+}
+
+func TestRemoveRouteUsesStoredAlias(t *testing.T) {
+	memory := NewMemoryProvider("example.com")
+	SetProvider(memory, false, zap.NewNop().Sugar())
+	batch := NewBatch("test")
+	if err := batch.AddRoute("web.example.com", []string{}, "lb.example.net", RouteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	// the caller does not know the alias the route was published with
+	batch = NewBatch("test")
+	if err := batch.RemoveRoute("web.example.com", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if record, ok := findRecord(t, memory, "web.example.com", "A"); ok {
+		t.Errorf("record %v left after removing the route", record)
+	}
+}
+
+func TestApplyIsolatesFailingRoute(t *testing.T) {
+	memory := NewMemoryProvider("example.com")
+	SetProvider(failingProvider{memory, "bad.example.com"}, false, zap.NewNop().Sugar())
+	batch := NewBatch("test")
+	for _, name := range []string{"a.example.com", "bad.example.com", "c.example.com"} {
+		if err := batch.AddRoute(name, []string{"10.0.0.1"}, "", RouteOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := batch.Apply(); err == nil {
+		t.Error("Apply succeeded with a failing route")
+	}
+	for _, name := range []string{"a.example.com", "c.example.com"} {
+		if _, ok := findRecord(t, memory, name, "A"); !ok {
+			t.Errorf("the route of %s was held back by the failing one", name)
+		}
+	}
+	if len(retries) != 1 || len(retries[0].units) != 1 || retries[0].units[0].key != "test/bad.example.com" {
+		t.Errorf("queued retries = %v, want only the failing route", retries)
+	}
+}
@@ -0,0 +1,408 @@
+package dns_providers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const cloudflareEndpoint = "https://api.cloudflare.com/client/v4"
+
+// cloudflarePageSize is the number of zones or records requested per page
+const cloudflarePageSize = 100
+
+func init() {
+	registerProvider("cloudflare", newCloudflareProvider)
+}
+
+// cloudflareProvider is the DNSProvider storing records in Cloudflare
+// zones. Cloudflare stores every value of a record set as its own record
+// with its own id, and has no alias records so alias routes are published
+// as CNAMEs. There is no batch API, changes are applied one by one and
+// when one fails the ones already applied are undone.
+type cloudflareProvider struct {
+	client   *http.Client
+	endpoint string
+	token    string
+}
+
+type cloudflareError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type cloudflareResultInfo struct {
+	Page       int `json:"page"`
+	TotalPages int `json:"total_pages"`
+}
+
+type cloudflareResponse struct {
+	Success    bool                 `json:"success"`
+	Errors     []cloudflareError    `json:"errors"`
+	Result     json.RawMessage      `json:"result"`
+	ResultInfo cloudflareResultInfo `json:"result_info"`
+}
+
+type cloudflareZone struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int64  `json:"ttl"`
+	Proxied bool   `json:"proxied"`
+}
+
+// newCloudflareProvider creates the cloudflare provider, the API token is
+// read from the CF_API_TOKEN environment variable.
+func newCloudflareProvider(config Config) (DNSProvider, error) {
+	p := &cloudflareProvider{
+		client:   http.DefaultClient,
+		endpoint: cloudflareEndpoint,
+		token:    os.Getenv("CF_API_TOKEN"),
+	}
+	// a custom endpoint is used to talk to a mock API server
+	if config.CloudflareEndpoint != "" {
+		p.endpoint = strings.TrimSuffix(config.CloudflareEndpoint, "/")
+	} else if p.token == "" {
+		return nil, fmt.Errorf("CF_API_TOKEN is needed for the cloudflare provider")
+	}
+	return p, nil
+}
+
+func (p *cloudflareProvider) Zones() ([]Zone, error) {
+	zones := make([]Zone, 0, 1)
+	err := p.list("/zones", url.Values{}, func(result json.RawMessage) error {
+		var page []cloudflareZone
+		if err := json.Unmarshal(result, &page); err != nil {
+			return err
+		}
+		for _, zone := range page {
+			zones = append(zones, Zone{ID: zone.ID, Name: normalizeName(zone.Name)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list zones: %v", err)
+	}
+	return zones, nil
+}
+
+func (p *cloudflareProvider) Records(zone Zone) ([]Record, error) {
+	cfRecords, err := p.records(zone, url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	// group the records sharing name and type into record sets
+	records := make([]Record, 0, len(cfRecords))
+	index := make(map[string]int)
+	for _, cfRecord := range cfRecords {
+		record := Record{
+			Name:    normalizeName(cfRecord.Name),
+			Type:    cfRecord.Type,
+			TTL:     cfRecord.TTL,
+			Proxied: cfRecord.Proxied,
+		}
+		key := recordKey(record)
+		i, ok := index[key]
+		if !ok {
+			i = len(records)
+			index[key] = i
+			records = append(records, record)
+		}
+		records[i].Targets = append(records[i].Targets, cfRecord.Content)
+	}
+	return records, nil
+}
+
+func (p *cloudflareProvider) ApplyChanges(zone Zone, changes []Change) error {
+	tx := &cloudflareTx{p: p, zone: zone}
+	for _, change := range changes {
+		err := tx.apply(change)
+		if err != nil {
+			err = fmt.Errorf("Failed to apply %s to %s: %v", change.Action, change.Record.Name, err)
+			if rollbackErr := tx.rollback(); rollbackErr != nil {
+				return fmt.Errorf("%v, and failed to undo the changes already applied: %v", err, rollbackErr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// cloudflareTx applies the changes of a single ApplyChanges call, keeping
+// what is needed to undo each request already sent
+type cloudflareTx struct {
+	p    *cloudflareProvider
+	zone Zone
+	undo []func() error
+}
+
+func (tx *cloudflareTx) apply(change Change) error {
+	desired := toCloudflareRecords(change.Record)
+	current, err := tx.p.records(tx.zone, url.Values{"name": {strings.TrimSuffix(normalizeName(change.Record.Name), ".")}})
+	if err != nil {
+		return err
+	}
+	switch change.Action {
+	case ActionCreate, ActionUpsert:
+		return tx.upsert(change.Action == ActionCreate, current, desired)
+	case ActionDelete:
+		return tx.delete(current, desired)
+	default:
+		return fmt.Errorf("Unknown change action %s", change.Action)
+	}
+}
+
+// upsert makes the records of a name match desired, reusing the ids of
+// the records already there
+func (tx *cloudflareTx) upsert(onlyCreate bool, current, desired []cloudflareRecord) error {
+	if len(desired) == 0 {
+		return fmt.Errorf("record has no values")
+	}
+	recordType := desired[0].Type
+	existing := make([]cloudflareRecord, 0, len(current))
+	for _, record := range current {
+		// a name can either have a CNAME or A records, get rid of both
+		if record.Type == recordType || ((recordType == "A" || recordType == "CNAME") &&
+			(record.Type == "A" || record.Type == "CNAME")) {
+			existing = append(existing, record)
+		}
+	}
+	if onlyCreate && len(existing) != 0 {
+		return fmt.Errorf("record already exists")
+	}
+	for i, record := range desired {
+		if i < len(existing) {
+			record.ID = existing[i].ID
+			if record.Type == existing[i].Type &&
+				record.Content == existing[i].Content &&
+				record.TTL == existing[i].TTL &&
+				record.Proxied == existing[i].Proxied {
+				continue
+			}
+			if err := tx.update(existing[i], record); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := tx.create(record); err != nil {
+			return err
+		}
+	}
+	for i := len(desired); i < len(existing); i++ {
+		if err := tx.remove(existing[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delete removes the records of desired, the records of that type at the
+// name must be exactly these
+func (tx *cloudflareTx) delete(current, desired []cloudflareRecord) error {
+	if len(desired) == 0 {
+		return fmt.Errorf("record has no values")
+	}
+	existing := make([]cloudflareRecord, 0, len(current))
+	for _, record := range current {
+		if record.Type == desired[0].Type {
+			existing = append(existing, record)
+		}
+	}
+	if len(existing) == 0 {
+		return fmt.Errorf("%s record not found", desired[0].Type)
+	}
+	if len(existing) != len(desired) {
+		return fmt.Errorf("%s record values provided do not match the current values", desired[0].Type)
+	}
+	for _, record := range desired {
+		found := false
+		for _, other := range existing {
+			if other.Content == record.Content && other.TTL == record.TTL && other.Proxied == record.Proxied {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s record values provided do not match the current values", record.Type)
+		}
+	}
+	for _, record := range existing {
+		if err := tx.remove(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx *cloudflareTx) create(record cloudflareRecord) error {
+	var resp cloudflareResponse
+	if err := tx.p.do("POST", tx.p.zonePath(tx.zone)+"/dns_records", nil, record, &resp); err != nil {
+		return err
+	}
+	var created cloudflareRecord
+	if err := json.Unmarshal(resp.Result, &created); err != nil {
+		return err
+	}
+	tx.undo = append(tx.undo, func() error {
+		return tx.p.do("DELETE", tx.p.zonePath(tx.zone)+"/dns_records/"+created.ID, nil, nil, nil)
+	})
+	return nil
+}
+
+func (tx *cloudflareTx) update(old, record cloudflareRecord) error {
+	if err := tx.p.do("PUT", tx.p.zonePath(tx.zone)+"/dns_records/"+record.ID, nil, record, nil); err != nil {
+		return err
+	}
+	tx.undo = append(tx.undo, func() error {
+		return tx.p.do("PUT", tx.p.zonePath(tx.zone)+"/dns_records/"+old.ID, nil, old, nil)
+	})
+	return nil
+}
+
+func (tx *cloudflareTx) remove(old cloudflareRecord) error {
+	if err := tx.p.do("DELETE", tx.p.zonePath(tx.zone)+"/dns_records/"+old.ID, nil, nil, nil); err != nil {
+		return err
+	}
+	// the record comes back with a new id
+	old.ID = ""
+	tx.undo = append(tx.undo, func() error {
+		return tx.p.do("POST", tx.p.zonePath(tx.zone)+"/dns_records", nil, old, nil)
+	})
+	return nil
+}
+
+// rollback undoes the requests already sent in reverse order, it keeps
+// going on errors and returns the first one
+func (tx *cloudflareTx) rollback() error {
+	var first error
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		if err := tx.undo[i](); err != nil && first == nil {
+			first = err
+		}
+	}
+	tx.undo = nil
+	return first
+}
+
+func (p *cloudflareProvider) records(zone Zone, query url.Values) ([]cloudflareRecord, error) {
+	records := make([]cloudflareRecord, 0, 1)
+	err := p.list(p.zonePath(zone)+"/dns_records", query, func(result json.RawMessage) error {
+		var page []cloudflareRecord
+		if err := json.Unmarshal(result, &page); err != nil {
+			return err
+		}
+		records = append(records, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list records in zone %s: %v", zone.Name, err)
+	}
+	return records, nil
+}
+
+func (p *cloudflareProvider) zonePath(zone Zone) string {
+	return "/zones/" + url.PathEscape(zone.ID)
+}
+
+// list requests every page of a listing, and hands the result of each of
+// them to handle
+func (p *cloudflareProvider) list(path string, query url.Values, handle func(json.RawMessage) error) error {
+	query.Set("per_page", strconv.Itoa(cloudflarePageSize))
+	for page := 1; ; page++ {
+		query.Set("page", strconv.Itoa(page))
+		var resp cloudflareResponse
+		if err := p.do("GET", path, query, nil, &resp); err != nil {
+			return err
+		}
+		if err := handle(resp.Result); err != nil {
+			return err
+		}
+		if resp.ResultInfo.TotalPages <= page {
+			return nil
+		}
+	}
+}
+
+// do sends a request to the cloudflare API, and decodes the response
+// envelope into out
+func (p *cloudflareProvider) do(method, path string, query url.Values, in interface{}, out *cloudflareResponse) error {
+	u := p.endpoint + path
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, u, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var envelope cloudflareResponse
+	if err := json.Unmarshal(msg, &envelope); err != nil || resp.StatusCode/100 != 2 || !envelope.Success {
+		return fmt.Errorf("%s %s returned %s: %s", method, u, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		*out = envelope
+	}
+	return nil
+}
+
+// toCloudflareRecords splits a record set into one cloudflare record per
+// value
+func toCloudflareRecords(record Record) []cloudflareRecord {
+	name := strings.TrimSuffix(normalizeName(record.Name), ".")
+	ttl := record.TTL
+	// proxied records must use the automatic TTL
+	if ttl == 0 || record.Proxied {
+		ttl = 1
+	}
+	// cloudflare has no alias records, point a CNAME to the load balancer
+	if record.Alias != "" {
+		return []cloudflareRecord{{
+			Type:    "CNAME",
+			Name:    name,
+			Content: record.Alias,
+			TTL:     ttl,
+			Proxied: record.Proxied,
+		}}
+	}
+	records := make([]cloudflareRecord, 0, len(record.Targets))
+	for _, target := range record.Targets {
+		records = append(records, cloudflareRecord{
+			Type:    record.Type,
+			Name:    name,
+			Content: target,
+			TTL:     ttl,
+			Proxied: record.Proxied && (record.Type == "A" || record.Type == "AAAA" || record.Type == "CNAME"),
+		})
+	}
+	return records
+}
@@ -0,0 +1,111 @@
+package dns_providers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeCloudflare is a mock of the cloudflare API serving a single zone
+type fakeCloudflare struct {
+	sync.Mutex
+	records map[string]cloudflareRecord
+	nextID  int
+}
+
+func (f *fakeCloudflare) reply(w http.ResponseWriter, result interface{}) {
+	raw, _ := json.Marshal(result)
+	json.NewEncoder(w).Encode(cloudflareResponse{
+		Success:    true,
+		Result:     raw,
+		ResultInfo: cloudflareResultInfo{Page: 1, TotalPages: 1},
+	})
+}
+
+func (f *fakeCloudflare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	const prefix = "/zones/z1/dns_records"
+	var record cloudflareRecord
+	if r.Method == "POST" || r.Method == "PUT" {
+		if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	id := strings.TrimPrefix(r.URL.Path, prefix+"/")
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/zones":
+		f.reply(w, []cloudflareZone{{ID: "z1", Name: "example.com"}})
+	case r.Method == "GET" && r.URL.Path == prefix:
+		records := make([]cloudflareRecord, 0, len(f.records))
+		for _, record := range f.records {
+			if name := r.URL.Query().Get("name"); name == "" || name == record.Name {
+				records = append(records, record)
+			}
+		}
+		sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+		f.reply(w, records)
+	case r.Method == "POST" && r.URL.Path == prefix:
+		f.nextID++
+		record.ID = "r" + strconv.Itoa(f.nextID)
+		f.records[record.ID] = record
+		f.reply(w, record)
+	case r.Method == "PUT" && f.records[id].ID != "":
+		f.records[id] = record
+		f.reply(w, record)
+	case r.Method == "DELETE" && f.records[id].ID != "":
+		delete(f.records, id)
+		f.reply(w, map[string]string{"id": id})
+	default:
+		http.Error(w, `{"success":false}`, http.StatusNotFound)
+	}
+}
+
+func init() {
+	contractBackends["cloudflare"] = func(t *testing.T) (DNSProvider, Zone, func()) {
+		provider, _, closeServer := newTestCloudflareProvider(t)
+		return provider, Zone{ID: "z1", Name: "example.com."}, closeServer
+	}
+}
+
+func newTestCloudflareProvider(t *testing.T) (DNSProvider, *fakeCloudflare, func()) {
+	fake := &fakeCloudflare{records: make(map[string]cloudflareRecord)}
+	server := httptest.NewServer(fake)
+	provider, err := newCloudflareProvider(Config{CloudflareEndpoint: server.URL})
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return provider, fake, server.Close
+}
+
+func TestCloudflareProxiedRecords(t *testing.T) {
+	provider, fake, closeServer := newTestCloudflareProvider(t)
+	defer closeServer()
+	zone := Zone{ID: "z1", Name: "example.com."}
+	err := provider.ApplyChanges(zone, []Change{
+		{Action: ActionUpsert, Record: Record{Name: "web.example.com", Type: "A", TTL: 300, Targets: []string{"10.0.0.1", "10.0.0.2"}, Proxied: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cloudflare only accepts the automatic TTL for proxied records
+	for _, record := range fake.records {
+		if !record.Proxied || record.TTL != 1 {
+			t.Errorf("record %+v is not proxied with the automatic TTL", record)
+		}
+	}
+	records, err := provider.Records(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || !records[0].Proxied || len(records[0].Targets) != 2 {
+		t.Errorf("Records() = %v, want a single proxied record set", records)
+	}
+}
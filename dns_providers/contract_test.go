@@ -0,0 +1,134 @@
+package dns_providers
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// contractBackends start every provider with an empty example.com zone for
+// TestProviderContract, the tests of each provider register theirs. The
+// function returned stops the backend.
+var contractBackends = map[string]func(t *testing.T) (DNSProvider, Zone, func()){
+	"memory": func(t *testing.T) (DNSProvider, Zone, func()) {
+		return NewMemoryProvider("example.com"), Zone{ID: "example.com", Name: "example.com."}, func() {}
+	},
+}
+
+// contractRecords returns the records of zone without the SOA and NS
+// records some backends always have
+func contractRecords(t *testing.T, p DNSProvider, zone Zone) []Record {
+	records, err := p.Records(zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := make([]Record, 0, len(records))
+	for _, record := range records {
+		if record.Type != "SOA" && record.Type != "NS" {
+			list = append(list, record)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return recordKey(list[i]) < recordKey(list[j]) })
+	return list
+}
+
+// contractMatches tells if the record read back is the record written,
+// alias records may be read back as a CNAME
+func contractMatches(wanted, got Record) bool {
+	if wanted.Alias != "" {
+		target := got.Alias
+		if got.Type == "CNAME" && len(got.Targets) == 1 {
+			target = got.Targets[0]
+		}
+		return normalizeName(got.Name) == normalizeName(wanted.Name) &&
+			strings.TrimSuffix(target, ".") == strings.TrimSuffix(wanted.Alias, ".")
+	}
+	return recordsEqual(got, wanted)
+}
+
+// checkContractRecords fails unless the zone has exactly the wanted
+// records, ordered by name and type
+func checkContractRecords(t *testing.T, p DNSProvider, zone Zone, step string, wanted ...Record) {
+	got := contractRecords(t, p, zone)
+	if len(got) != len(wanted) {
+		t.Fatalf("%s: records %v, want %v", step, got, wanted)
+	}
+	for i := range wanted {
+		if !contractMatches(wanted[i], got[i]) {
+			t.Fatalf("%s: record %v, want %v", step, got[i], wanted[i])
+		}
+	}
+}
+
+func TestProviderContract(t *testing.T) {
+	names := make([]string, 0, len(contractBackends))
+	for name := range contractBackends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		start := contractBackends[name]
+		t.Run(name, func(t *testing.T) {
+			p, zone, stop := start(t)
+			defer stop()
+			testProviderContract(t, p, zone)
+		})
+	}
+}
+
+func testProviderContract(t *testing.T, p DNSProvider, zone Zone) {
+	zones, err := p.Zones()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(zones, []Zone{zone}) {
+		t.Fatalf("Zones() = %v, want [%v]", zones, zone)
+	}
+	web := Record{Name: "web.example.com.", Type: "A", TTL: 60, Targets: []string{"10.0.0.1", "10.0.0.2"}}
+	txt := Record{Name: "web.example.com.", Type: "TXT", TTL: 300, Targets: []string{`"owner=test"`}}
+	apply := func(step string, changes ...Change) {
+		if err := p.ApplyChanges(zone, changes); err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+	}
+	fail := func(step string, changes ...Change) {
+		if err := p.ApplyChanges(zone, changes); err == nil {
+			t.Fatalf("%s succeeded", step)
+		}
+	}
+
+	apply("creating the records",
+		Change{Action: ActionCreate, Record: web},
+		Change{Action: ActionCreate, Record: txt})
+	checkContractRecords(t, p, zone, "after the creation", web, txt)
+
+	fail("creating an existing record set",
+		Change{Action: ActionCreate, Record: Record{Name: web.Name, Type: "A", TTL: 60, Targets: []string{"10.0.0.3"}}})
+	fail("deleting a record set with other values",
+		Change{Action: ActionDelete, Record: Record{Name: web.Name, Type: "A", TTL: 60, Targets: []string{"10.0.0.1"}}})
+	checkContractRecords(t, p, zone, "after the failed changes", web, txt)
+
+	// the addresses of a name become an alias, and the other way around
+	alias := Record{Name: web.Name, Type: "A", Alias: "lb.example.net"}
+	apply("upserting an alias", Change{Action: ActionUpsert, Record: alias})
+	checkContractRecords(t, p, zone, "after the upsert of the alias", alias, txt)
+	addresses := Record{Name: web.Name, Type: "A", TTL: 300, Targets: []string{"10.0.0.3"}}
+	apply("upserting addresses", Change{Action: ActionUpsert, Record: addresses})
+	checkContractRecords(t, p, zone, "after the upsert of the addresses", addresses, txt)
+	apply("upserting an alias again", Change{Action: ActionUpsert, Record: alias})
+
+	// a batch is applied as a whole
+	fail("a batch deleting a missing record set",
+		Change{Action: ActionUpsert, Record: Record{Name: "api.example.com.", Type: "A", TTL: 300, Targets: []string{"10.0.0.4"}}},
+		Change{Action: ActionDelete, Record: Record{Name: "old.example.com.", Type: "A", TTL: 300, Targets: []string{"10.0.0.5"}}})
+	checkContractRecords(t, p, zone, "after the failed batch", alias, txt)
+
+	// the records read back can be deleted as they are
+	changes := make([]Change, 0, 2)
+	for _, record := range contractRecords(t, p, zone) {
+		changes = append(changes, Change{Action: ActionDelete, Record: record})
+	}
+	apply("deleting the records read", changes...)
+	checkContractRecords(t, p, zone, "after the deletion")
+}
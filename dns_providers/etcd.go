@@ -0,0 +1,268 @@
+package dns_providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+const etcdTimeout = 10 * time.Second
+
+func init() {
+	registerProvider("etcd", newEtcdProvider)
+}
+
+// etcdProvider is the DNSProvider writing records into etcd using the
+// SkyDNS layout read by the CoreDNS etcd plugin. A name is stored under its
+// reversed labels (www.example.com is /skydns/com/example/www) with one key
+// per value below it, hosts are published as A/AAAA records when they are
+// IPs and as CNAMEs otherwise. Changes of a batch are applied in a single
+// etcd transaction.
+type etcdProvider struct {
+	client *clientv3.Client
+	prefix string
+	zones  []string
+}
+
+// etcdService is the value stored in every SkyDNS key
+type etcdService struct {
+	Host string `json:"host,omitempty"`
+	Text string `json:"text,omitempty"`
+	TTL  uint32 `json:"ttl,omitempty"`
+}
+
+func newEtcdProvider(config Config) (DNSProvider, error) {
+	if len(config.EtcdEndpoints) == 0 || len(config.EtcdZones) == 0 {
+		return nil, fmt.Errorf("etcd endpoints and at least one zone are needed for the etcd provider")
+	}
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   config.EtcdEndpoints,
+		DialTimeout: etcdTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	prefix := config.EtcdPrefix
+	if prefix == "" {
+		prefix = "/skydns"
+	}
+	return &etcdProvider{
+		client: client,
+		prefix: strings.TrimSuffix(prefix, "/"),
+		zones:  config.EtcdZones,
+	}, nil
+}
+
+// Zones returns the configured zones, they must match the zones served by
+// the CoreDNS etcd plugin
+func (p *etcdProvider) Zones() ([]Zone, error) {
+	zones := make([]Zone, 0, len(p.zones))
+	for _, name := range p.zones {
+		name = normalizeName(name)
+		zones = append(zones, Zone{ID: p.path(name), Name: name})
+	}
+	return zones, nil
+}
+
+func (p *etcdProvider) Records(zone Zone) ([]Record, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	resp, err := p.client.Get(ctx, p.path(zone.Name)+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list records in zone %s: %v", zone.Name, err)
+	}
+	// group the keys sharing name and type into record sets
+	sets := make(map[string]*Record)
+	for _, kv := range resp.Kvs {
+		key := string(kv.Key)
+		var service etcdService
+		if err := json.Unmarshal(kv.Value, &service); err != nil {
+			sLog.Warnf("Ignoring invalid SkyDNS record %s: %v", key, err)
+			continue
+		}
+		// the last label of the key is the id of the value
+		record := fromEtcdService(p.name(key[:strings.LastIndex(key, "/")]), service)
+		setKey := recordKey(record)
+		set, ok := sets[setKey]
+		if !ok {
+			set = &record
+			sets[setKey] = set
+		} else {
+			set.Targets = append(set.Targets, record.Targets...)
+		}
+	}
+	records := make([]Record, 0, len(sets))
+	for _, set := range sets {
+		records = append(records, *set)
+	}
+	sort.Slice(records, func(i, j int) bool { return recordKey(records[i]) < recordKey(records[j]) })
+	return records, nil
+}
+
+func (p *etcdProvider) ApplyChanges(zone Zone, changes []Change) error {
+	ctx, cancel := context.WithTimeout(context.Background(), etcdTimeout)
+	defer cancel()
+	cmps := make([]clientv3.Cmp, 0, len(changes))
+	ops := make([]clientv3.Op, 0, len(changes))
+	for _, change := range changes {
+		values, err := p.toKeys(change.Record)
+		if err != nil {
+			return err
+		}
+		current, err := p.keys(ctx, change.Record.Name)
+		if err != nil {
+			return err
+		}
+		switch change.Action {
+		case ActionCreate, ActionUpsert:
+			for key, value := range current {
+				if _, ok := values[key]; ok || !sameFamily(change.Record, value) {
+					continue
+				}
+				if change.Action == ActionCreate {
+					return fmt.Errorf("Tried to create record %s but it already exists", change.Record.Name)
+				}
+				// delete the values the record no longer has
+				cmps = append(cmps, clientv3.Compare(clientv3.Value(key), "=", value))
+				ops = append(ops, clientv3.OpDelete(key))
+			}
+			for key, value := range values {
+				if change.Action == ActionCreate {
+					cmps = append(cmps, clientv3.Compare(clientv3.CreateRevision(key), "=", 0))
+				}
+				ops = append(ops, clientv3.OpPut(key, value))
+			}
+		case ActionDelete:
+			// every value must exist exactly as given, and no other
+			for key, value := range current {
+				if _, ok := values[key]; !ok && sameFamily(change.Record, value) {
+					return fmt.Errorf("Tried to delete record %s but the values provided do not match the current values", change.Record.Name)
+				}
+			}
+			for key, value := range values {
+				cmps = append(cmps, clientv3.Compare(clientv3.Value(key), "=", value))
+				ops = append(ops, clientv3.OpDelete(key))
+			}
+		default:
+			return fmt.Errorf("Unknown change action %s", change.Action)
+		}
+	}
+	resp, err := p.client.Txn(ctx).If(cmps...).Then(ops...).Commit()
+	if err != nil {
+		return fmt.Errorf("Failed to update zone %s: %v", zone.Name, err)
+	}
+	if !resp.Succeeded {
+		return fmt.Errorf("Failed to update zone %s: records changed or do not match the values given", zone.Name)
+	}
+	return nil
+}
+
+// keys returns the keys and values currently stored for name, ignoring
+// the keys of its subdomains
+func (p *etcdProvider) keys(ctx context.Context, name string) (map[string]string, error) {
+	dir := p.path(name) + "/"
+	resp, err := p.client.Get(ctx, dir, clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get records of %s: %v", name, err)
+	}
+	keys := make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		key := string(kv.Key)
+		if !strings.Contains(strings.TrimPrefix(key, dir), "/") {
+			keys[key] = string(kv.Value)
+		}
+	}
+	return keys, nil
+}
+
+// toKeys returns the keys and values storing record, one key per value
+func (p *etcdProvider) toKeys(record Record) (map[string]string, error) {
+	targets := record.Targets
+	if record.Alias != "" {
+		targets = []string{record.Alias}
+	}
+	ttl := record.TTL
+	if ttl == 0 {
+		ttl = 300
+	}
+	keys := make(map[string]string, len(targets))
+	for _, target := range targets {
+		service := etcdService{TTL: uint32(ttl)}
+		var id string
+		if record.Type == "TXT" {
+			service.Text = strings.Trim(target, `"`)
+			id = "text/" + service.Text
+		} else {
+			service.Host = strings.TrimSuffix(target, ".")
+			id = "host/" + service.Host
+		}
+		value, err := json.Marshal(service)
+		if err != nil {
+			return nil, err
+		}
+		// the id only depends on the stored value so that a record read
+		// back from Records maps to the same keys
+		h := fnv.New32a()
+		h.Write([]byte(id))
+		keys[fmt.Sprintf("%s/%08x", p.path(record.Name), h.Sum32())] = string(value)
+	}
+	return keys, nil
+}
+
+// path returns the SkyDNS key of a name
+func (p *etcdProvider) path(name string) string {
+	labels := strings.Split(strings.TrimSuffix(normalizeName(name), "."), ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return p.prefix + "/" + strings.Join(labels, "/")
+}
+
+// name returns the name stored in a SkyDNS key
+func (p *etcdProvider) name(path string) string {
+	labels := strings.Split(strings.TrimPrefix(path, p.prefix+"/"), "/")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return normalizeName(strings.Join(labels, "."))
+}
+
+func fromEtcdService(name string, service etcdService) Record {
+	record := Record{
+		Name: name,
+		TTL:  int64(service.TTL),
+	}
+	switch ip := net.ParseIP(service.Host); {
+	case service.Text != "":
+		record.Type = "TXT"
+		record.Targets = []string{`"` + service.Text + `"`}
+	case ip != nil && ip.To4() != nil:
+		record.Type = "A"
+		record.Targets = []string{service.Host}
+	case ip != nil:
+		record.Type = "AAAA"
+		record.Targets = []string{service.Host}
+	default:
+		record.Type = "CNAME"
+		record.Targets = []string{domainWithTrailingDot(service.Host)}
+	}
+	return record
+}
+
+// sameFamily tells if a stored value would conflict with record, TXT
+// values only conflict with TXT records while hosts conflict with every
+// other record
+func sameFamily(record Record, value string) bool {
+	var service etcdService
+	if err := json.Unmarshal([]byte(value), &service); err != nil {
+		return false
+	}
+	return (record.Type == "TXT") == (service.Text != "")
+}
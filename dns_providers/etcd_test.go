@@ -0,0 +1,73 @@
+package dns_providers
+
+import (
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/embed"
+	"go.uber.org/zap"
+)
+
+// freeURL returns a local URL nothing listens on yet
+func freeURL(t *testing.T) url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}
+}
+
+func init() {
+	contractBackends["etcd"] = func(t *testing.T) (DNSProvider, Zone, func()) {
+		provider, stop := newTestEtcdProvider(t)
+		return provider, Zone{ID: "/skydns/com/example", Name: "example.com."}, stop
+	}
+}
+
+// newTestEtcdProvider starts an embedded single member etcd cluster and
+// connects the provider to it
+func newTestEtcdProvider(t *testing.T) (DNSProvider, func()) {
+	dir, err := ioutil.TempDir("", "etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	clientURL, peerURL := freeURL(t), freeURL(t)
+	cfg.LCUrls, cfg.ACUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.LPUrls, cfg.APUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	stop := func() {
+		e.Close()
+		os.RemoveAll(dir)
+	}
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(time.Minute):
+		stop()
+		t.Fatal("etcd did not start")
+	}
+	sLog = zap.NewNop().Sugar()
+	provider, err := newEtcdProvider(Config{
+		EtcdEndpoints: []string{clientURL.String()},
+		EtcdZones:     []string{"example.com"},
+	})
+	if err != nil {
+		stop()
+		t.Fatal(err)
+	}
+	return provider, func() {
+		provider.(*etcdProvider).client.Close()
+		stop()
+	}
+}
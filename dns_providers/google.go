@@ -0,0 +1,234 @@
+package dns_providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+)
+
+const googleDNSEndpoint = "https://dns.googleapis.com/dns/v1"
+const googleDNSScope = "https://www.googleapis.com/auth/ndev.clouddns.readwrite"
+
+func init() {
+	registerProvider("google", newGoogleProvider)
+}
+
+// googleProvider is the DNSProvider storing records in Google Cloud DNS
+// managed zones. Cloud DNS has no alias records so alias routes are
+// published as CNAMEs, and since it has no UPSERT the current record sets
+// of a name are deleted in the same change that adds the new ones.
+type googleProvider struct {
+	client   *http.Client
+	endpoint string
+	project  string
+}
+
+type googleManagedZone struct {
+	Name    string `json:"name"`
+	DNSName string `json:"dnsName"`
+}
+
+type googleManagedZones struct {
+	ManagedZones  []googleManagedZone `json:"managedZones"`
+	NextPageToken string              `json:"nextPageToken"`
+}
+
+type googleRecordSet struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	TTL     int64    `json:"ttl,omitempty"`
+	Rrdatas []string `json:"rrdatas"`
+}
+
+type googleRecordSets struct {
+	Rrsets        []googleRecordSet `json:"rrsets"`
+	NextPageToken string            `json:"nextPageToken"`
+}
+
+type googleChange struct {
+	Additions []googleRecordSet `json:"additions,omitempty"`
+	Deletions []googleRecordSet `json:"deletions,omitempty"`
+}
+
+func newGoogleProvider(config Config) (DNSProvider, error) {
+	if config.GoogleProject == "" {
+		return nil, fmt.Errorf("a project is needed for the google provider")
+	}
+	p := &googleProvider{
+		client:   http.DefaultClient,
+		endpoint: googleDNSEndpoint,
+		project:  config.GoogleProject,
+	}
+	// a custom endpoint is used to talk to a local stand-in of the API
+	// which does not need any credentials
+	if config.GoogleEndpoint != "" {
+		p.endpoint = strings.TrimSuffix(config.GoogleEndpoint, "/")
+		return p, nil
+	}
+	client, err := google.DefaultClient(context.Background(), googleDNSScope)
+	if err != nil {
+		return nil, err
+	}
+	p.client = client
+	return p, nil
+}
+
+func (p *googleProvider) Zones() ([]Zone, error) {
+	zones := make([]Zone, 0, 1)
+	pageToken := ""
+	for {
+		var out googleManagedZones
+		query := url.Values{}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		err := p.do("GET", "/managedZones", query, nil, &out)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list managed zones: %v", err)
+		}
+		for _, mz := range out.ManagedZones {
+			zones = append(zones, Zone{ID: mz.Name, Name: mz.DNSName})
+		}
+		if out.NextPageToken == "" {
+			return zones, nil
+		}
+		pageToken = out.NextPageToken
+	}
+}
+
+func (p *googleProvider) Records(zone Zone) ([]Record, error) {
+	rrsets, err := p.recordSets(zone, url.Values{})
+	if err != nil {
+		return nil, err
+	}
+	records := make([]Record, 0, len(rrsets))
+	for _, rrset := range rrsets {
+		records = append(records, Record{
+			Name:    rrset.Name,
+			Type:    rrset.Type,
+			TTL:     rrset.TTL,
+			Targets: rrset.Rrdatas,
+		})
+	}
+	return records, nil
+}
+
+func (p *googleProvider) ApplyChanges(zone Zone, changes []Change) error {
+	var change googleChange
+	// a record set may only be deleted once per change, i.e. when a route
+	// is removed and added again in the same batch
+	deleted := make(map[string]bool)
+	deleteRecordSet := func(rrset googleRecordSet) {
+		key := rrset.Name + "/" + rrset.Type
+		if !deleted[key] {
+			deleted[key] = true
+			change.Deletions = append(change.Deletions, rrset)
+		}
+	}
+	for _, c := range changes {
+		rrset := toGoogleRecordSet(c.Record)
+		switch c.Action {
+		case ActionCreate:
+			change.Additions = append(change.Additions, rrset)
+		case ActionUpsert:
+			// replace the record set of the same type, and whatever the
+			// name points to as an A record may become a CNAME and vice
+			// versa
+			current, err := p.recordSets(zone, url.Values{"name": {rrset.Name}})
+			if err != nil {
+				return err
+			}
+			for _, existing := range current {
+				if existing.Type == rrset.Type || (addressType(existing.Type) && addressType(rrset.Type)) {
+					deleteRecordSet(existing)
+				}
+			}
+			change.Additions = append(change.Additions, rrset)
+		case ActionDelete:
+			deleteRecordSet(rrset)
+		default:
+			return fmt.Errorf("Unknown change action %s", c.Action)
+		}
+	}
+	return p.do("POST", "/managedZones/"+url.PathEscape(zone.ID)+"/changes", nil, change, nil)
+}
+
+// addressType tells if records of type point a name to an address, a name
+// may only have one of them
+func addressType(recordType string) bool {
+	return recordType == "A" || recordType == "CNAME"
+}
+
+func (p *googleProvider) recordSets(zone Zone, query url.Values) ([]googleRecordSet, error) {
+	rrsets := make([]googleRecordSet, 0, 1)
+	for {
+		var out googleRecordSets
+		err := p.do("GET", "/managedZones/"+url.PathEscape(zone.ID)+"/rrsets", query, nil, &out)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list record sets in zone %s: %v", zone.ID, err)
+		}
+		rrsets = append(rrsets, out.Rrsets...)
+		if out.NextPageToken == "" {
+			return rrsets, nil
+		}
+		query.Set("pageToken", out.NextPageToken)
+	}
+}
+
+// do sends a request to the Cloud DNS API of the project, and decodes the
+// JSON response into out
+func (p *googleProvider) do(method, path string, query url.Values, in, out interface{}) error {
+	u := p.endpoint + "/projects/" + url.PathEscape(p.project) + path
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, u, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s returned %s: %s", method, u, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func toGoogleRecordSet(record Record) googleRecordSet {
+	rrset := googleRecordSet{
+		Name:    normalizeName(record.Name),
+		Type:    record.Type,
+		TTL:     record.TTL,
+		Rrdatas: record.Targets,
+	}
+	// Cloud DNS has no alias records, point a CNAME to the load balancer
+	if record.Alias != "" {
+		rrset.Type = "CNAME"
+		rrset.Rrdatas = []string{domainWithTrailingDot(record.Alias)}
+	}
+	if rrset.TTL == 0 {
+		rrset.TTL = 300
+	}
+	return rrset
+}
@@ -0,0 +1,139 @@
+package dns_providers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// fakeCloudDNS is a stand-in of the Cloud DNS API holding the record sets
+// of a single managed zone, like Cloud DNS it rejects a change adding an
+// existing record set or deleting one which does not match exactly
+type fakeCloudDNS struct {
+	sync.Mutex
+	rrsets map[string]googleRecordSet
+}
+
+func (f *fakeCloudDNS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	const prefix = "/projects/test/managedZones"
+	switch {
+	case r.Method == "GET" && r.URL.Path == prefix:
+		json.NewEncoder(w).Encode(googleManagedZones{
+			ManagedZones: []googleManagedZone{{Name: "example", DNSName: "example.com."}},
+		})
+	case r.Method == "GET" && r.URL.Path == prefix+"/example/rrsets":
+		var out googleRecordSets
+		for _, rrset := range f.rrsets {
+			if name := r.URL.Query().Get("name"); name == "" || name == rrset.Name {
+				out.Rrsets = append(out.Rrsets, rrset)
+			}
+		}
+		json.NewEncoder(w).Encode(out)
+	case r.Method == "POST" && r.URL.Path == prefix+"/example/changes":
+		var change googleChange
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rrsets := make(map[string]googleRecordSet, len(f.rrsets))
+		for key, rrset := range f.rrsets {
+			rrsets[key] = rrset
+		}
+		for _, rrset := range change.Deletions {
+			key := rrset.Name + "/" + rrset.Type
+			if current, ok := rrsets[key]; !ok || !reflect.DeepEqual(current, rrset) {
+				http.Error(w, "conditionNotMet: "+key, http.StatusPreconditionFailed)
+				return
+			}
+			delete(rrsets, key)
+		}
+		for _, rrset := range change.Additions {
+			key := rrset.Name + "/" + rrset.Type
+			if _, ok := rrsets[key]; ok {
+				http.Error(w, "alreadyExists: "+key, http.StatusConflict)
+				return
+			}
+			rrsets[key] = rrset
+		}
+		f.rrsets = rrsets
+		w.Write([]byte("{}"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func init() {
+	contractBackends["google"] = func(t *testing.T) (DNSProvider, Zone, func()) {
+		provider, _, closeServer := newTestGoogleProvider(t)
+		return provider, Zone{ID: "example", Name: "example.com."}, closeServer
+	}
+}
+
+func newTestGoogleProvider(t *testing.T, rrsets ...googleRecordSet) (DNSProvider, *fakeCloudDNS, func()) {
+	fake := &fakeCloudDNS{rrsets: make(map[string]googleRecordSet)}
+	for _, rrset := range rrsets {
+		fake.rrsets[rrset.Name+"/"+rrset.Type] = rrset
+	}
+	server := httptest.NewServer(fake)
+	provider, err := newGoogleProvider(Config{GoogleProject: "test", GoogleEndpoint: server.URL + "/"})
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return provider, fake, server.Close
+}
+
+func TestGoogleUpsertReplacesRecordSets(t *testing.T) {
+	owner := Record{Name: "web.example.com.", Type: "TXT", TTL: 300, Targets: []string{`"owner=old"`}}
+	provider, fake, closeServer := newTestGoogleProvider(t,
+		googleRecordSet{Name: "web.example.com.", Type: "A", TTL: 300, Rrdatas: []string{"10.0.0.1"}},
+		googleRecordSet{Name: owner.Name, Type: "TXT", TTL: 300, Rrdatas: owner.Targets},
+		googleRecordSet{Name: "web.example.com.", Type: "MX", TTL: 300, Rrdatas: []string{"10 mail.example.com."}},
+	)
+	defer closeServer()
+	zone := Zone{ID: "example", Name: "example.com."}
+	newOwner := Record{Name: "web.example.com.", Type: "TXT", TTL: 300, Targets: []string{`"owner=new"`}}
+	err := provider.ApplyChanges(zone, []Change{
+		{Action: ActionUpsert, Record: Record{Name: "web.example.com", Type: "CNAME", Alias: "lb.example.net"}},
+		{Action: ActionUpsert, Record: newOwner},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.rrsets["web.example.com./A"]; ok {
+		t.Error("the A record set was not replaced by the CNAME")
+	}
+	want := googleRecordSet{Name: "web.example.com.", Type: "CNAME", TTL: 300, Rrdatas: []string{"lb.example.net."}}
+	if got := fake.rrsets["web.example.com./CNAME"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("CNAME record set = %v, want %v", got, want)
+	}
+	if got := fake.rrsets[newOwner.Name+"/TXT"]; !reflect.DeepEqual(got.Rrdatas, newOwner.Targets) {
+		t.Errorf("TXT record set = %v, want %v", got.Rrdatas, newOwner.Targets)
+	}
+	if _, ok := fake.rrsets["web.example.com./MX"]; !ok {
+		t.Error("the MX record set of the name was deleted")
+	}
+}
+
+func TestGoogleDeleteThenUpsert(t *testing.T) {
+	provider, fake, closeServer := newTestGoogleProvider(t,
+		googleRecordSet{Name: "web.example.com.", Type: "A", TTL: 300, Rrdatas: []string{"10.0.0.1"}},
+	)
+	defer closeServer()
+	zone := Zone{ID: "example", Name: "example.com."}
+	err := provider.ApplyChanges(zone, []Change{
+		{Action: ActionDelete, Record: Record{Name: "web.example.com", Type: "A", Targets: []string{"10.0.0.1"}}},
+		{Action: ActionUpsert, Record: Record{Name: "web.example.com", Type: "A", Targets: []string{"10.0.0.2"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.rrsets["web.example.com./A"].Rrdatas; !reflect.DeepEqual(got, []string{"10.0.0.2"}) {
+		t.Errorf("A record set = %v, want [10.0.0.2]", got)
+	}
+}
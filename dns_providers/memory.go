@@ -0,0 +1,183 @@
+package dns_providers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+func init() {
+	registerProvider("memory", func(config Config) (DNSProvider, error) {
+		if len(config.MemoryZones) == 0 {
+			return nil, fmt.Errorf("at least one zone is needed for the memory provider")
+		}
+		return NewMemoryProvider(config.MemoryZones...), nil
+	})
+}
+
+// MemoryProvider is a DNSProvider keeping zones and records in memory,
+// which makes it possible to run the controller without any cloud
+// credentials and to assert on the resulting records. Changes follow
+// route53 ChangeResourceRecordSets semantics: CREATE fails if the record
+// exists, DELETE must match the existing record exactly, a CNAME cannot
+// share its name with another record and a failing change leaves the
+// whole batch unapplied.
+type MemoryProvider struct {
+	lock  sync.RWMutex
+	zones map[string]memoryZone
+}
+
+// memoryZone contains the records of a zone indexed by name and type
+type memoryZone map[string]Record
+
+// NewMemoryProvider creates a MemoryProvider with the given empty zones
+func NewMemoryProvider(zones ...string) *MemoryProvider {
+	p := &MemoryProvider{
+		zones: make(map[string]memoryZone),
+	}
+	for _, zone := range zones {
+		p.AddZone(zone)
+	}
+	return p
+}
+
+// AddZone adds an empty zone to the provider, adding an existing zone
+// does nothing.
+func (p *MemoryProvider) AddZone(name string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	name = normalizeName(name)
+	if _, ok := p.zones[name]; !ok {
+		p.zones[name] = make(memoryZone)
+	}
+}
+
+func (p *MemoryProvider) Zones() ([]Zone, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	zones := make([]Zone, 0, len(p.zones))
+	for name := range p.zones {
+		zones = append(zones, Zone{ID: strings.TrimSuffix(name, "."), Name: name})
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].Name < zones[j].Name })
+	return zones, nil
+}
+
+func (p *MemoryProvider) Records(zone Zone) ([]Record, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	records, ok := p.zones[normalizeName(zone.Name)]
+	if !ok {
+		return nil, fmt.Errorf("No such zone %s", zone.Name)
+	}
+	list := make([]Record, 0, len(records))
+	for _, record := range records {
+		list = append(list, copyRecord(record))
+	}
+	sort.Slice(list, func(i, j int) bool { return recordKey(list[i]) < recordKey(list[j]) })
+	return list, nil
+}
+
+func (p *MemoryProvider) ApplyChanges(zone Zone, changes []Change) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	zoneName := normalizeName(zone.Name)
+	records, ok := p.zones[zoneName]
+	if !ok {
+		return fmt.Errorf("No such zone %s", zone.Name)
+	}
+	// apply the changes to a copy so a failing change leaves the zone as is
+	updated := make(memoryZone, len(records))
+	for key, record := range records {
+		updated[key] = record
+	}
+	for _, change := range changes {
+		record := copyRecord(change.Record)
+		record.Name = normalizeName(record.Name)
+		if record.Name != zoneName && !strings.HasSuffix(record.Name, "."+zoneName) {
+			return fmt.Errorf("Record %s is not in zone %s", record.Name, zoneName)
+		}
+		key := recordKey(record)
+		existing, exists := updated[key]
+		switch change.Action {
+		case ActionCreate:
+			if exists {
+				return fmt.Errorf("Tried to create record %s %s but it already exists", record.Name, record.Type)
+			}
+			if err := updated.checkConflict(record); err != nil {
+				return err
+			}
+			updated[key] = record
+		case ActionUpsert:
+			if err := updated.checkConflict(record); err != nil {
+				return err
+			}
+			updated[key] = record
+		case ActionDelete:
+			if !exists {
+				return fmt.Errorf("Tried to delete record %s %s but it was not found", record.Name, record.Type)
+			}
+			if !recordsEqual(existing, record) {
+				return fmt.Errorf("Tried to delete record %s %s but the values provided do not match the current values", record.Name, record.Type)
+			}
+			delete(updated, key)
+		default:
+			return fmt.Errorf("Unknown change action %s", change.Action)
+		}
+	}
+	p.zones[zoneName] = updated
+	return nil
+}
+
+// checkConflict fails when record would share its name with a CNAME, or is
+// a CNAME sharing its name with another record
+func (z memoryZone) checkConflict(record Record) error {
+	for _, existing := range z {
+		if existing.Name != record.Name || existing.Type == record.Type {
+			continue
+		}
+		if existing.Type == "CNAME" || record.Type == "CNAME" {
+			return fmt.Errorf("Tried to write record %s %s but it conflicts with the %s record of the same name", record.Name, record.Type, existing.Type)
+		}
+	}
+	return nil
+}
+
+// normalizeName lower cases a domain name and makes it fully qualified
+func normalizeName(name string) string {
+	return domainWithTrailingDot(strings.ToLower(name))
+}
+
+func recordKey(record Record) string {
+	return record.Name + "/" + record.Type
+}
+
+func copyRecord(record Record) Record {
+	if record.Targets != nil {
+		record.Targets = append([]string{}, record.Targets...)
+	}
+	return record
+}
+
+// recordsEqual compares two records ignoring the order of their targets
+func recordsEqual(a, b Record) bool {
+	if normalizeName(a.Name) != normalizeName(b.Name) ||
+		a.Type != b.Type ||
+		a.TTL != b.TTL ||
+		a.Proxied != b.Proxied ||
+		strings.TrimSuffix(a.Alias, ".") != strings.TrimSuffix(b.Alias, ".") ||
+		len(a.Targets) != len(b.Targets) {
+		return false
+	}
+	aTargets := append([]string{}, a.Targets...)
+	bTargets := append([]string{}, b.Targets...)
+	sort.Strings(aTargets)
+	sort.Strings(bTargets)
+	for i := range aTargets {
+		if aTargets[i] != bTargets[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,38 @@
+package dns_providers
+
+import "testing"
+
+func newTestMemoryProvider(t *testing.T, records ...Record) *MemoryProvider {
+	p := NewMemoryProvider("example.com")
+	for _, record := range records {
+		if err := p.ApplyChanges(Zone{Name: "example.com."}, []Change{{Action: ActionCreate, Record: record}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p
+}
+
+func TestMemoryRejectsCNAMEConflict(t *testing.T) {
+	web := Record{Name: "web.example.com", Type: "A", TTL: 300, Targets: []string{"10.0.0.1"}}
+	api := Record{Name: "api.example.com", Type: "CNAME", TTL: 300, Targets: []string{"lb.example.net"}}
+	p := newTestMemoryProvider(t, web, api)
+	conflicts := []Record{
+		{Name: "web.example.com", Type: "CNAME", TTL: 300, Targets: []string{"lb.example.net"}},
+		{Name: "api.example.com", Type: "A", TTL: 300, Targets: []string{"10.0.0.2"}},
+	}
+	for _, record := range conflicts {
+		for _, action := range []string{ActionCreate, ActionUpsert} {
+			if err := p.ApplyChanges(Zone{Name: "example.com."}, []Change{{Action: action, Record: record}}); err == nil {
+				t.Errorf("%s of %v next to a conflicting record succeeded", action, record)
+			}
+		}
+	}
+	// replacing the A record in one batch is fine
+	err := p.ApplyChanges(Zone{Name: "example.com."}, []Change{
+		{Action: ActionDelete, Record: web},
+		{Action: ActionCreate, Record: conflicts[0]},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
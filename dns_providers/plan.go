@@ -0,0 +1,70 @@
+package dns_providers
+
+// Actions of a PlannedChange
+const (
+	PlanCreate = "create"
+	PlanUpdate = "update"
+	PlanDelete = "delete"
+)
+
+// PlannedChange is a change Reconcile would apply, Previous is the record
+// replaced by an update
+type PlannedChange struct {
+	Zone     string  `json:"zone"`
+	Action   string  `json:"action"`
+	Record   Record  `json:"record"`
+	Previous *Record `json:"previous,omitempty"`
+}
+
+// Plan returns the changes Reconcile would apply for the records owned by
+// id to match desired, without applying them
+func Plan(id string, desired []Route) ([]PlannedChange, error) {
+	plan, _, err := planChanges(id, desired)
+	if err != nil {
+		return nil, err
+	}
+	planned := make([]PlannedChange, 0)
+	for _, zc := range plan {
+		planned = append(planned, zc.planned()...)
+	}
+	return planned, nil
+}
+
+// planned describes the changes of the zone as PlannedChanges
+func (zc zoneChanges) planned() []PlannedChange {
+	planned := make([]PlannedChange, 0, len(zc.changes))
+	for _, change := range zc.changes {
+		pc := PlannedChange{
+			Zone:   zc.zone.Name,
+			Action: PlanDelete,
+			Record: change.Record,
+		}
+		if change.Action != ActionDelete {
+			pc.Action = PlanCreate
+			if pc.Previous = previousRecord(change.Record, zc.current); pc.Previous != nil {
+				pc.Action = PlanUpdate
+			}
+		}
+		planned = append(planned, pc)
+	}
+	return planned
+}
+
+// previousRecord finds the record of current record replaces, A records
+// replace CNAMEs as providers without alias records publish them as CNAMEs,
+// and CNAMEs replace A records when a route switches to a CNAME target
+func previousRecord(record Record, current []Record) *Record {
+	name := normalizeName(record.Name)
+	for i := range current {
+		old := &current[i]
+		if normalizeName(old.Name) != name {
+			continue
+		}
+		if old.Type == record.Type ||
+			(record.Type == "A" && old.Type == "CNAME") ||
+			(record.Type == "CNAME" && old.Type == "A") {
+			return old
+		}
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package dns_providers
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/victor-fdez/kube-route53-traefik/metrics"
+)
+
+// DNSProvider is implemented by every DNS backend. AddRoute and RemoveRoute
+// translate the routes computed from the cluster view into record changes
+// and hand them to the provider selected in Setup.
+type DNSProvider interface {
+	// Zones lists every zone (hosted zone in route53) the provider manages.
+	Zones() ([]Zone, error)
+	// Records lists the record sets currently stored in zone.
+	Records(zone Zone) ([]Record, error)
+	// ApplyChanges applies all changes to zone, either all of them succeed
+	// or none of them are applied.
+	ApplyChanges(zone Zone, changes []Change) error
+}
+
+// ZoneFinder is implemented by the providers able to tell which zone a
+// domain belongs to without listing every zone, AddRoute uses it instead
+// of Zones when available.
+type ZoneFinder interface {
+	ZoneFor(domain string) (*Zone, error)
+}
+
+// ChangeLimiter is implemented by the providers limiting the size of a
+// single ApplyChanges call, larger batches are split into several calls
+// without ever splitting the changes of a single route.
+type ChangeLimiter interface {
+	// BatchLimits returns the limits of a single call.
+	BatchLimits() BatchLimits
+}
+
+// BatchLimits limit the size of a single ApplyChanges call the way route53
+// counts it: every value, or alias, is a record and its length counts as
+// characters, UPSERTs count twice. Zero is no limit.
+type BatchLimits struct {
+	MaxRecords int `json:"maxRecords,omitempty"`
+	MaxChars   int `json:"maxChars,omitempty"`
+}
+
+// Fits tells if changes can be applied in a single call.
+func (l BatchLimits) Fits(changes []Change) bool {
+	records, chars := 0, 0
+	for _, change := range changes {
+		n, c := 1, len(change.Record.Alias)
+		if change.Record.Alias == "" {
+			n = len(change.Record.Targets)
+			for _, target := range change.Record.Targets {
+				c += len(target)
+			}
+		}
+		if change.Action == ActionUpsert {
+			n, c = 2*n, 2*c
+		}
+		records += n
+		chars += c
+	}
+	return (l.MaxRecords == 0 || records <= l.MaxRecords) && (l.MaxChars == 0 || chars <= l.MaxChars)
+}
+
+// Zone is a DNS zone managed by a provider, Name is always fully qualified
+// (i.e. example.com.)
+type Zone struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Record is a record set within a zone. Records pointing to a load balancer
+// use Alias instead of Targets, providers without alias records are free to
+// publish them as a CNAME. Proxied is only used by cloudflare.
+type Record struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	TTL     int64    `json:"ttl,omitempty"`
+	Targets []string `json:"targets,omitempty"`
+	Alias   string   `json:"alias,omitempty"`
+	Proxied bool     `json:"proxied,omitempty"`
+}
+
+// Actions supported by a Change, they follow route53 ChangeResourceRecordSets
+// semantics.
+const (
+	ActionCreate = "CREATE"
+	ActionUpsert = "UPSERT"
+	ActionDelete = "DELETE"
+)
+
+// Change is a single action applied to a record set.
+type Change struct {
+	Action string `json:"action"`
+	Record Record `json:"record"`
+}
+
+// Config selects and configures the DNS provider used by Setup.
+type Config struct {
+	Provider string
+	DryRun   bool
+	// Policy is either PolicySync, the default, or PolicyUpsertOnly and
+	// decides whether Reconcile deletes the owned records nobody needs
+	// anymore
+	Policy string
+	// MaxAttempts is how many times failed changes are applied before
+	// giving up, throttled attempts are allowed three times as many tries
+	MaxAttempts int
+	// RetryFile persists the failed changes waiting to be retried across
+	// restarts, they are only kept in memory when empty
+	RetryFile string
+	// MemoryZones are the zones served by the memory provider
+	MemoryZones []string
+	// GoogleProject is the project owning the Cloud DNS managed zones,
+	// GoogleEndpoint overrides the Cloud DNS API endpoint
+	GoogleProject  string
+	GoogleEndpoint string
+	// AzureSubscriptionID and AzureResourceGroups locate the Azure DNS
+	// zones, AzureEndpoint overrides the ARM endpoint
+	AzureSubscriptionID string
+	AzureResourceGroups []string
+	AzureEndpoint       string
+	// CloudflareEndpoint overrides the cloudflare API endpoint
+	CloudflareEndpoint string
+	// RFC2136Server is the host:port receiving the dynamic updates,
+	// RFC2136Zones are the zones listed with AXFR, and updates are signed
+	// with RFC2136TSIGKey using RFC2136TSIGAlgorithm when set
+	RFC2136Server        string
+	RFC2136Zones         []string
+	RFC2136TSIGKey       string
+	RFC2136TSIGAlgorithm string
+	// EtcdEndpoints are the etcd servers the SkyDNS records are written to
+	// under EtcdPrefix, for the zones in EtcdZones
+	EtcdEndpoints []string
+	EtcdPrefix    string
+	EtcdZones     []string
+	// WebhookURL is the base URL of the sidecar implementing the webhook
+	// provider protocol
+	WebhookURL string
+}
+
+type providerFactory func(config Config) (DNSProvider, error)
+
+var providerFactories = make(map[string]providerFactory)
+var provider DNSProvider
+var providerName string
+
+// registerProvider makes a provider available to Setup under name, providers
+// call it from their init function.
+func registerProvider(name string, factory providerFactory) {
+	if _, ok := providerFactories[name]; ok {
+		panic(fmt.Sprintf("DNS provider %s registered twice", name))
+	}
+	providerFactories[name] = factory
+}
+
+// Providers returns the names of all the registered providers.
+func Providers() []string {
+	names := make([]string, 0, len(providerFactories))
+	for name := range providerFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewProvider creates the provider named in config
+func NewProvider(config Config) (DNSProvider, error) {
+	factory, ok := providerFactories[config.Provider]
+	if !ok {
+		return nil, fmt.Errorf("Unknown DNS provider %s, expected one of %v", config.Provider, Providers())
+	}
+	p, err := factory(config)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to setup DNS provider %s: %v", config.Provider, err)
+	}
+	return p, nil
+}
+
+// Setup creates the provider named in config, and resets the routes
+// managed so far.
+func Setup(config Config, SLog *zap.SugaredLogger) error {
+	switch config.Policy {
+	case "":
+		config.Policy = PolicySync
+	case PolicyUpsertOnly, PolicySync:
+	default:
+		return fmt.Errorf("Unknown policy %s, expected %s or %s", config.Policy, PolicyUpsertOnly, PolicySync)
+	}
+	p, err := NewProvider(config)
+	if err != nil {
+		return err
+	}
+	SetProvider(p, config.DryRun, SLog)
+	providerName = config.Provider
+	policy = config.Policy
+	if config.MaxAttempts > 0 {
+		maxAttempts = config.MaxAttempts
+	}
+	retryFile = config.RetryFile
+	if err := loadRetries(); err != nil {
+		sLog.Warnf("Ignoring the retries saved in %s: %v", retryFile, err)
+	}
+	sLog.Infof("Using DNS provider %s with policy %s", config.Provider, policy)
+	if dryRun {
+		sLog.Infof("Running in DRYRUN mode")
+	}
+	return nil
+}
+
+// SetProvider replaces the provider used by AddRoute and RemoveRoute, and
+// resets the routes managed so far. Tests use it to inject fake providers.
+func SetProvider(p DNSProvider, DryRun bool, SLog *zap.SugaredLogger) {
+	routesLock.Lock()
+	routes = make(Routes)
+	routesLock.Unlock()
+	metrics.Routes.Set(0)
+	policy = PolicySync
+	maxAttempts = defaultMaxAttempts
+	retries = nil
+	retryFile = ""
+	provider = p
+	dryRun = DryRun
+	sLog = SLog
+}
+
+// listZones, listRecords and applyChanges call the provider and record
+// the metrics of the call
+func listZones() ([]Zone, error) {
+	start := time.Now()
+	zones, err := provider.Zones()
+	observeCall("zones", start, err)
+	return zones, err
+}
+
+func listRecords(zone Zone) ([]Record, error) {
+	start := time.Now()
+	records, err := provider.Records(zone)
+	observeCall("records", start, err)
+	return records, err
+}
+
+func applyChanges(zone Zone, changes []Change) error {
+	start := time.Now()
+	err := provider.ApplyChanges(zone, changes)
+	observeCall("apply", start, err)
+	return err
+}
+
+func observeCall(operation string, start time.Time, err error) {
+	metrics.ProviderRequests.WithLabelValues(providerName, operation).Inc()
+	metrics.ProviderDuration.WithLabelValues(providerName, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.ProviderErrors.WithLabelValues(providerName, operation).Inc()
+	}
+}
+
+// pingInterval is how long the result of Ping is reused, readiness probes
+// must not eat the API rate limits of the provider
+const pingInterval = 30 * time.Second
+
+var pingLock sync.Mutex
+var pingTime time.Time
+var pingErr error
+
+// Ping tells if the provider can be reached by listing its zones
+func Ping() error {
+	pingLock.Lock()
+	defer pingLock.Unlock()
+	if provider == nil {
+		return fmt.Errorf("No DNS provider setup")
+	}
+	if time.Since(pingTime) > pingInterval {
+		_, pingErr = listZones()
+		pingTime = time.Now()
+	}
+	return pingErr
+}
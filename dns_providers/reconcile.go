@@ -0,0 +1,320 @@
+package dns_providers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Policies deciding what Reconcile is allowed to change
+const (
+	// PolicySync also deletes the records nobody needs anymore, only the
+	// records proven owned through their TXT record are ever deleted
+	PolicySync = "sync"
+	// PolicyUpsertOnly creates and updates records but never deletes the
+	// records nobody needs anymore
+	PolicyUpsertOnly = "upsert-only"
+)
+
+var policy string
+
+// zoneChanges are the changes reconciling a zone
+type zoneChanges struct {
+	zone    Zone
+	changes []Change
+	// current are the records of the zone the changes were computed from
+	current []Record
+}
+
+// NewRoute creates a route the cluster needs, routes are handed to
+// Reconcile
+func NewRoute(subdomain string, ips []string, alias string, options RouteOptions) Route {
+	return Route{
+		subdomain: subdomain,
+		ips:       ips,
+		alias:     alias,
+		options:   options,
+	}
+}
+
+// Reconcile lists the records in every zone, compares the records owned by
+// id with the desired routes and applies only the difference. Afterwards
+// the stored routes match desired, so routes created before a restart can
+// be removed. It returns the changes applied, on dry run the changes it
+// would have applied.
+func Reconcile(id string, desired []Route) ([]PlannedChange, error) {
+	plan, desiredRoutes, err := planChanges(id, desired)
+	if err != nil {
+		return nil, err
+	}
+	applied := make([]PlannedChange, 0)
+	failed := make(map[string]bool)
+	// the units of the zones which failed, the ones applied are stored and
+	// the others retried
+	pending := make(map[string]bool)
+	var partial []changeUnit
+	var retry []zoneUnits
+	var errs []string
+	for _, zc := range plan {
+		if len(zc.changes) == 0 {
+			continue
+		}
+		for _, change := range zc.changes {
+			sLog.Infof("%s %s Record in zone %s for domain %s", change.Action, change.Record.Type, zc.zone.ID, change.Record.Name)
+		}
+		if dryRun {
+			sLog.Infof("DRY RUN: We normally would have applied %d changes to %s", len(zc.changes), zc.zone.ID)
+			applied = append(applied, zc.planned()...)
+			continue
+		}
+		units := zc.units(id, desiredRoutes)
+		done, undone, err := applyUnits(zc.zone, units)
+		zc.changes = unitChanges(done)
+		applied = append(applied, zc.planned()...)
+		if err != nil {
+			failed[zc.zone.ID] = true
+			errs = append(errs, fmt.Sprintf("zone %s: %v", zc.zone.ID, err))
+			for _, unit := range units {
+				pending[unit.key] = true
+			}
+			partial = append(partial, done...)
+			retry = append(retry, zoneUnits{zone: zc.zone, units: undone, err: err})
+		}
+	}
+	// keep the stored routes of the zones we could not reconcile, but for
+	// the routes applied or which needed no change
+	reconciled := make(Routes)
+	for key, route := range routes {
+		if failed[route.zone.ID] {
+			reconciled[key] = route
+		}
+	}
+	for _, route := range desiredRoutes {
+		key := id + "/" + route.subdomain
+		if !failed[route.zone.ID] || !pending[key] {
+			reconciled[key] = route
+		}
+	}
+	routesLock.Lock()
+	routes = reconciled
+	routesLock.Unlock()
+	storeUnits(partial)
+	// the queued changes are outdated, the ones which failed again are
+	// retried later instead of being lost. Upsert-only leaves the routes
+	// no longer wanted alone, so their queued removal still has to be
+	// done.
+	wantedKeys := make(map[string]bool, len(desiredRoutes))
+	for _, route := range desiredRoutes {
+		wantedKeys[id+"/"+route.subdomain] = true
+	}
+	dropRetries(func(zone Zone, unit changeUnit) bool {
+		return policy == PolicySync || unit.route != nil || wantedKeys[unit.key]
+	})
+	for _, zu := range retry {
+		queueRetry(zu.zone, zu.units, zu.err)
+	}
+	if len(errs) != 0 {
+		return applied, fmt.Errorf("Unable to reconcile DNS records: %s", strings.Join(errs, "; "))
+	}
+	return applied, nil
+}
+
+// zoneUnits are the units of a zone which failed to be applied with err
+type zoneUnits struct {
+	zone  Zone
+	units []changeUnit
+	err   error
+}
+
+// units groups the changes of the zone by route, the units carry the key
+// of the route they change and the route stored once they are applied,
+// nil for the routes no longer wanted
+func (zc zoneChanges) units(id string, desired []Route) []changeUnit {
+	wanted := make(map[string]Route)
+	for _, route := range desired {
+		if route.zone.ID == zc.zone.ID {
+			wanted[normalizeName(route.subdomain)] = route
+		}
+	}
+	units := groupChanges(zc.changes)
+	for i := range units {
+		route, ok := wanted[units[i].key]
+		if !ok {
+			units[i].key = id + "/" + strings.TrimSuffix(units[i].key, ".")
+			continue
+		}
+		units[i].key = id + "/" + route.subdomain
+		units[i].route = &route
+	}
+	return units
+}
+
+// unitChanges returns the changes of units, in order
+func unitChanges(units []changeUnit) []Change {
+	changes := make([]Change, 0, len(units))
+	for _, unit := range units {
+		changes = append(changes, unit.changes...)
+	}
+	return changes
+}
+
+// planChanges computes the changes needed in every zone for the records
+// owned by id to match desired. The desired routes id may write are
+// returned with their zone set.
+func planChanges(id string, desired []Route) ([]zoneChanges, []Route, error) {
+	zones, err := listZones()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unable to list zones: %v", err)
+	}
+	plan := make(map[string]*zoneChanges)
+	for _, zone := range zones {
+		plan[zone.ID] = &zoneChanges{zone: zone}
+	}
+	// find the zone of every desired route, a hostname claimed twice only
+	// gets the first route
+	wanted := make(map[string]map[string]Route)
+	for _, route := range desired {
+		zone, err := zoneFor(route.subdomain, zones)
+		if err != nil {
+			sLog.Warnf("Skipping %s: %v", route.subdomain, err)
+			continue
+		}
+		name := normalizeName(route.subdomain)
+		if _, ok := plan[zone.ID]; !ok {
+			plan[zone.ID] = &zoneChanges{zone: *zone}
+		}
+		if _, ok := wanted[zone.ID]; !ok {
+			wanted[zone.ID] = make(map[string]Route)
+		}
+		if _, ok := wanted[zone.ID][name]; ok {
+			continue
+		}
+		route.zone = *zone
+		route.domain = zone.Name
+		wanted[zone.ID][name] = route
+	}
+	result := make([]zoneChanges, 0, len(plan))
+	desiredRoutes := make([]Route, 0, len(desired))
+	for _, zc := range plan {
+		current, err := listRecords(zc.zone)
+		if err != nil {
+			return nil, nil, err
+		}
+		changes, owned := diffRecords(id, wanted[zc.zone.ID], current)
+		zc.changes = changes
+		zc.current = current
+		result = append(result, *zc)
+		desiredRoutes = append(desiredRoutes, owned...)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].zone.Name < result[j].zone.Name })
+	return result, desiredRoutes, nil
+}
+
+// diffRecords returns the changes turning the A and CNAME records of
+// current owned by id into wanted, along with the wanted routes id may
+// write. Names created by someone else are left alone.
+func diffRecords(id string, wanted map[string]Route, current []Record) ([]Change, []Route) {
+	changes := make([]Change, 0)
+	owned := make([]Route, 0, len(wanted))
+	owners := readOwners(current)
+	existing := make(map[string][]Record)
+	for _, record := range current {
+		if record.Type == "A" || record.Type == "CNAME" {
+			name := normalizeName(record.Name)
+			existing[name] = append(existing[name], record)
+		}
+	}
+	names := make([]string, 0, len(wanted))
+	for name := range wanted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		route := wanted[name]
+		o, ok := owners[name]
+		if ok && o.id != id {
+			sLog.Warnf("Not updating %s, it is owned by %s", name, o.id)
+			continue
+		}
+		if !ok && len(existing[name]) != 0 {
+			sLog.Warnf("Not updating %s, it was not created by %s", name, id)
+			continue
+		}
+		owned = append(owned, route)
+		record := newRecord(route.subdomain, route.ips, route.alias, route.options)
+		matched := false
+		for _, old := range existing[name] {
+			if recordMatches(record, old) {
+				matched = true
+			} else if old.Type != record.Type {
+				// a CNAME would conflict with the A record, and the other
+				// way around, whatever the policy the owned record of the
+				// old type has to go
+				changes = append(changes, Change{Action: ActionDelete, Record: old})
+			}
+		}
+		if !matched {
+			changes = append(changes, Change{Action: ActionUpsert, Record: record})
+		}
+		txt := ownerRecord(route.subdomain, id, route.options.Resource)
+		if !ok || !recordsEqual(txt, o.record) {
+			changes = append(changes, Change{Action: ActionUpsert, Record: txt})
+		}
+	}
+	if policy != PolicySync {
+		return changes, owned
+	}
+	// delete the records id owns which are no longer wanted
+	unwanted := make([]string, 0)
+	for name, o := range owners {
+		if _, ok := wanted[name]; !ok && o.id == id {
+			unwanted = append(unwanted, name)
+		}
+	}
+	sort.Strings(unwanted)
+	for _, name := range unwanted {
+		o := owners[name]
+		for _, record := range existing[name] {
+			changes = append(changes, Change{Action: ActionDelete, Record: record})
+		}
+		changes = append(changes, Change{Action: ActionDelete, Record: o.record})
+	}
+	return changes, owned
+}
+
+// recordMatches tells if current already is the wanted record, providers
+// without alias records store them as a CNAME
+func recordMatches(wanted, current Record) bool {
+	if wanted.Proxied && current.Proxied {
+		// cloudflare reads proxied records back with its automatic TTL
+		current.TTL = wanted.TTL
+	}
+	if wanted.Alias != "" && current.Type == "CNAME" {
+		return len(current.Targets) == 1 &&
+			sameHostname(current.Targets[0], wanted.Alias) &&
+			current.Proxied == wanted.Proxied
+	}
+	if wanted.Alias != "" {
+		return current.Type == wanted.Type && sameHostname(current.Alias, wanted.Alias)
+	}
+	return current.Alias == "" && recordsEqual(wanted, current)
+}
+
+// sameHostname compares load balancer hostnames the way route53 returns
+// them, lower cased and with a dualstack prefix
+func sameHostname(a, b string) bool {
+	clean := func(hostname string) string {
+		hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+		return strings.TrimPrefix(hostname, "dualstack.")
+	}
+	return clean(a) == clean(b)
+}
+
+// zoneFor finds the zone of domain among zones, or asks the provider when
+// it is a ZoneFinder
+func zoneFor(domain string, zones []Zone) (*Zone, error) {
+	if finder, ok := provider.(ZoneFinder); ok {
+		return finder.ZoneFor(domain)
+	}
+	return findMostSpecificZoneForDomain(domain, zones)
+}
@@ -0,0 +1,120 @@
+package dns_providers
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestReconcileDeletesStaleOwnedRecords(t *testing.T) {
+	memory := NewMemoryProvider("example.com")
+	SetProvider(memory, false, zap.NewNop().Sugar())
+	web := NewRoute("web.example.com", []string{"10.0.0.1"}, "", RouteOptions{})
+	old := NewRoute("old.example.com", []string{"10.0.0.2"}, "", RouteOptions{})
+	if _, err := Reconcile("test", []Route{web, old}); err != nil {
+		t.Fatal(err)
+	}
+	// a record created by someone else is never deleted
+	err := memory.ApplyChanges(Zone{Name: "example.com."}, []Change{
+		{Action: ActionCreate, Record: Record{Name: "manual.example.com", Type: "A", TTL: 300, Targets: []string{"10.0.0.3"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// after a restart old.example.com is no longer wanted
+	SetProvider(memory, false, zap.NewNop().Sugar())
+	if _, err := Reconcile("test", []Route{web}); err != nil {
+		t.Fatal(err)
+	}
+	if record, ok := findRecord(t, memory, "old.example.com", "A"); ok {
+		t.Errorf("stale record %v left with the default policy", record)
+	}
+	if record, ok := findRecord(t, memory, ownerName("old.example.com"), "TXT"); ok {
+		t.Errorf("stale owner record %v left with the default policy", record)
+	}
+	for _, name := range []string{"web.example.com", "manual.example.com"} {
+		if _, ok := findRecord(t, memory, name, "A"); !ok {
+			t.Errorf("the record of %s was deleted", name)
+		}
+	}
+}
+
+func TestReconcileReplacesConflictingType(t *testing.T) {
+	memory := NewMemoryProvider("example.com")
+	SetProvider(memory, false, zap.NewNop().Sugar())
+	policy = PolicyUpsertOnly
+	web := NewRoute("web.example.com", []string{"10.0.0.1"}, "", RouteOptions{})
+	if _, err := Reconcile("test", []Route{web}); err != nil {
+		t.Fatal(err)
+	}
+	// the route now points to a CNAME target, even upsert-only has to
+	// delete the A record
+	web = NewRoute("web.example.com", nil, "lb.example.net", RouteOptions{CNAME: true})
+	if _, err := Reconcile("test", []Route{web}); err != nil {
+		t.Fatal(err)
+	}
+	if record, ok := findRecord(t, memory, "web.example.com", "A"); ok {
+		t.Errorf("record %v conflicts with the CNAME", record)
+	}
+	if _, ok := findRecord(t, memory, "web.example.com", "CNAME"); !ok {
+		t.Error("the CNAME record was not created")
+	}
+}
+
+func TestRecordMatchesProxiedRecord(t *testing.T) {
+	wanted := newRecord("web.example.com", []string{"10.0.0.2", "10.0.0.1"}, "", RouteOptions{Proxied: true})
+	// cloudflare reads proxied records back with its automatic TTL
+	current := Record{Name: "web.example.com.", Type: "A", TTL: 1, Targets: []string{"10.0.0.1", "10.0.0.2"}, Proxied: true}
+	if !recordMatches(wanted, current) {
+		t.Errorf("record %v does not match the wanted %v", current, wanted)
+	}
+	current.Proxied = false
+	if recordMatches(wanted, current) {
+		t.Errorf("record %v which is not proxied matches the wanted %v", current, wanted)
+	}
+}
+
+func TestReconcileKeepsPartialSuccesses(t *testing.T) {
+	memory := NewMemoryProvider("example.com")
+	SetProvider(failingProvider{memory, "bad.example.com"}, false, zap.NewNop().Sugar())
+	desired := []Route{
+		NewRoute("a.example.com", []string{"10.0.0.1"}, "", RouteOptions{}),
+		NewRoute("bad.example.com", []string{"10.0.0.2"}, "", RouteOptions{}),
+		NewRoute("c.example.com", []string{"10.0.0.3"}, "", RouteOptions{}),
+	}
+	applied, err := Reconcile("test", desired)
+	if err == nil {
+		t.Error("Reconcile succeeded with a failing route")
+	}
+	names := make(map[string]bool)
+	for _, change := range applied {
+		names[change.Record.Name] = true
+	}
+	if !names["a.example.com."] || !names["c.example.com."] || names["bad.example.com."] {
+		t.Errorf("changes reported applied %v, want the ones of a and c", applied)
+	}
+	for _, key := range []string{"test/a.example.com", "test/c.example.com"} {
+		if _, ok := routes[key]; !ok {
+			t.Errorf("route %s applied but not stored", key)
+		}
+	}
+	if _, ok := routes["test/bad.example.com"]; ok {
+		t.Error("the failing route was stored")
+	}
+	if len(retries) != 1 || len(retries[0].units) != 1 || retries[0].units[0].key != "test/bad.example.com" {
+		t.Fatalf("queued retries = %v, want only the failing route", retries)
+	}
+
+	// once the provider recovers the retry stores the route
+	provider = memory
+	retries[0].next = retries[0].next.Add(-time.Hour)
+	RetryChanges()
+	if _, ok := routes["test/bad.example.com"]; !ok {
+		t.Error("the route retried was not stored")
+	}
+	if _, ok := findRecord(t, memory, "bad.example.com", "A"); !ok {
+		t.Error("the record retried was not created")
+	}
+}
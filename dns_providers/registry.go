@@ -0,0 +1,114 @@
+package dns_providers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The registry keeps the owner of every record we create in a companion
+// TXT record, so records created by humans or other tools are never
+// touched. The TXT record lives under a prefixed name as it may not share
+// the name of a CNAME, which some providers publish instead of aliases.
+const (
+	heritage       = "kube-route53-traefik"
+	ownerPrefix    = "_" + heritage + "."
+	wildcardPrefix = "_" + heritage + "-wildcard."
+)
+
+// owner is the content of a companion TXT record
+type owner struct {
+	id       string
+	resource string
+	record   Record
+}
+
+// ownerName returns the name of the TXT record owning name
+func ownerName(name string) string {
+	name = normalizeName(name)
+	if strings.HasPrefix(name, "*.") {
+		return wildcardPrefix + strings.TrimPrefix(name, "*.")
+	}
+	return ownerPrefix + name
+}
+
+// ownedName returns the name owned by the TXT record named name
+func ownedName(name string) (string, bool) {
+	name = normalizeName(name)
+	if strings.HasPrefix(name, wildcardPrefix) {
+		return "*." + strings.TrimPrefix(name, wildcardPrefix), true
+	}
+	if strings.HasPrefix(name, ownerPrefix) {
+		return strings.TrimPrefix(name, ownerPrefix), true
+	}
+	return "", false
+}
+
+// ownerRecord creates the TXT record telling name is owned by id on behalf
+// of resource (i.e. ingress/default/web)
+func ownerRecord(name, id, resource string) Record {
+	return Record{
+		Name:    ownerName(name),
+		Type:    "TXT",
+		TTL:     300,
+		Targets: []string{fmt.Sprintf(`"heritage=%s,owner=%s,resource=%s"`, heritage, id, resource)},
+	}
+}
+
+// parseOwner reads a companion TXT record, other TXT records are ignored
+func parseOwner(record Record) (owner, bool) {
+	if record.Type != "TXT" || len(record.Targets) != 1 {
+		return owner{}, false
+	}
+	o := owner{record: record}
+	isOwner := false
+	for _, field := range strings.Split(strings.Trim(record.Targets[0], `"`), ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "heritage":
+			isOwner = kv[1] == heritage
+		case "owner":
+			o.id = kv[1]
+		case "resource":
+			o.resource = kv[1]
+		}
+	}
+	return o, isOwner
+}
+
+// readOwners returns the owner of every name having a companion TXT
+// record in records
+func readOwners(records []Record) map[string]owner {
+	owners := make(map[string]owner)
+	for _, record := range records {
+		name, ok := ownedName(record.Name)
+		if !ok {
+			continue
+		}
+		if o, ok := parseOwner(record); ok {
+			owners[name] = o
+		}
+	}
+	return owners
+}
+
+// claimName makes sure name can be written by id, it either must not exist
+// in records or be owned by id
+func claimName(id, name string, records []Record) error {
+	name = normalizeName(name)
+	o, owned := readOwners(records)[name]
+	if owned && o.id == id {
+		return nil
+	}
+	if owned {
+		return fmt.Errorf("Refusing to update %s, it is owned by %s", name, o.id)
+	}
+	for _, record := range records {
+		if normalizeName(record.Name) == name && (record.Type == "A" || record.Type == "CNAME") {
+			return fmt.Errorf("Refusing to update %s, it was not created by %s", name, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package dns_providers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+const (
+	defaultMaxAttempts = 10
+	retryBaseDelay     = time.Second
+	retryMaxDelay      = 5 * time.Minute
+	// throttled changes start waiting longer as retrying right away only
+	// makes the throttling worse
+	throttledBaseDelay = 5 * time.Second
+	// throttled failures are not counted as attempts, but a zone which is
+	// throttled for good is given up after this many times maxAttempts
+	// tries
+	throttledTriesFactor = 3
+)
+
+// retryItem are the changes to a zone which failed to be applied, they are
+// kept until they succeed, get superseded or fail maxAttempts times
+type retryItem struct {
+	zone  Zone
+	units []changeUnit
+	// attempts counts the failures other than throttling, tries counts
+	// every failure and drives the backoff
+	attempts int
+	tries    int
+	next     time.Time
+}
+
+var retries []*retryItem
+var maxAttempts int
+
+// retryFile is where the queued retries are persisted so they survive a
+// restart, they are only kept in memory when empty
+var retryFile string
+var jitter = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// queueRetry keeps the units which failed to be applied to zone with err
+func queueRetry(zone Zone, units []changeUnit, err error) {
+	if len(units) == 0 {
+		return
+	}
+	item := &retryItem{zone: zone, units: units}
+	if item.failed(err) {
+		retries = append(retries, item)
+		saveRetries()
+	}
+}
+
+// failed records a failure of item and schedules the next attempt, it
+// returns false when item must be given up
+func (item *retryItem) failed(err error) bool {
+	item.tries++
+	base := retryBaseDelay
+	if isThrottled(err) {
+		base = throttledBaseDelay
+	} else {
+		item.attempts++
+	}
+	if item.attempts >= maxAttempts || item.tries >= throttledTriesFactor*maxAttempts {
+		sLog.Errorf("Giving up %d changes to zone %s after %d attempts: %v",
+			len(item.units), item.zone.ID, item.tries, err)
+		return false
+	}
+	delay := base << uint(item.tries-1)
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	// wait between half and one and a half times the delay, so the
+	// retries of many changes do not hit the provider at the same time
+	delay = delay/2 + time.Duration(jitter.Int63n(int64(delay)))
+	item.next = time.Now().Add(delay)
+	sLog.Warnf("Retrying %d changes to zone %s in %v (attempt %d): %v",
+		len(item.units), item.zone.ID, delay, item.tries, err)
+	return true
+}
+
+// RetryChanges applies again the changes which failed and are due, it is
+// called periodically by the watch loop
+func RetryChanges() {
+	now := time.Now()
+	pending := make([]*retryItem, 0, len(retries))
+	due := make([]*retryItem, 0, len(retries))
+	for _, item := range retries {
+		if item.next.After(now) {
+			pending = append(pending, item)
+		} else {
+			due = append(due, item)
+		}
+	}
+	if len(due) == 0 {
+		return
+	}
+	retries = pending
+	defer saveRetries()
+	for _, item := range due {
+		if len(item.units) == 0 {
+			continue
+		}
+		applied, failed, err := applyUnits(item.zone, item.units)
+		storeUnits(applied)
+		if err == nil {
+			sLog.Infof("Applied %d changes to zone %s after %d attempts", len(applied), item.zone.ID, item.tries+1)
+			continue
+		}
+		item.units = failed
+		if item.failed(err) {
+			retries = append(retries, item)
+		}
+	}
+}
+
+// dropRetries forgets the queued units matching drop, they are superseded
+// by newer changes
+func dropRetries(drop func(zone Zone, unit changeUnit) bool) {
+	dropped := false
+	for _, item := range retries {
+		units := item.units[:0]
+		for _, unit := range item.units {
+			if drop(item.zone, unit) {
+				dropped = true
+			} else {
+				units = append(units, unit)
+			}
+		}
+		item.units = units
+	}
+	if dropped {
+		saveRetries()
+	}
+}
+
+// isThrottled tells if err is the provider throttling the requests, or
+// route53 still applying a previous change batch, both go away by waiting
+func isThrottled(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case "Throttling", "ThrottlingException", "PriorRequestNotComplete":
+			return true
+		}
+	}
+	// the HTTP providers put the status of the response in their errors,
+	// and Cloud DNS also reports its quotas as rateLimitExceeded
+	msg := err.Error()
+	return strings.Contains(msg, "429 Too Many Requests") || strings.Contains(msg, "rateLimitExceeded")
+}
+
+// storedRetry is a retryItem as persisted in retryFile
+type storedRetry struct {
+	Zone     Zone         `json:"zone"`
+	Units    []storedUnit `json:"units"`
+	Attempts int          `json:"attempts"`
+	Tries    int          `json:"tries"`
+	Next     time.Time    `json:"next"`
+}
+
+// storedUnit is a changeUnit as persisted in retryFile, Route is nil when
+// the changes remove the route
+type storedUnit struct {
+	Key     string       `json:"key"`
+	Changes []Change     `json:"changes"`
+	Route   *storedRoute `json:"route,omitempty"`
+}
+
+type storedRoute struct {
+	Subdomain string       `json:"subdomain"`
+	Domain    string       `json:"domain"`
+	Ips       []string     `json:"ips,omitempty"`
+	Alias     string       `json:"alias,omitempty"`
+	Options   RouteOptions `json:"options"`
+	Zone      Zone         `json:"zone"`
+}
+
+// saveRetries writes the queued retries to retryFile, a failure is only
+// logged as the retries are still kept in memory
+func saveRetries() {
+	if retryFile == "" {
+		return
+	}
+	stored := make([]storedRetry, 0, len(retries))
+	for _, item := range retries {
+		if len(item.units) == 0 {
+			continue
+		}
+		units := make([]storedUnit, 0, len(item.units))
+		for _, unit := range item.units {
+			su := storedUnit{Key: unit.key, Changes: unit.changes}
+			if route := unit.route; route != nil {
+				su.Route = &storedRoute{
+					Subdomain: route.subdomain,
+					Domain:    route.domain,
+					Ips:       route.ips,
+					Alias:     route.alias,
+					Options:   route.options,
+					Zone:      route.zone,
+				}
+			}
+			units = append(units, su)
+		}
+		stored = append(stored, storedRetry{
+			Zone:     item.zone,
+			Units:    units,
+			Attempts: item.attempts,
+			Tries:    item.tries,
+			Next:     item.next,
+		})
+	}
+	data, err := json.Marshal(stored)
+	if err == nil {
+		// replace the file at once so a crash never leaves half of it
+		tmp := retryFile + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, retryFile)
+		}
+	}
+	if err != nil {
+		sLog.Warnf("Unable to save the retries to %s: %v", retryFile, err)
+	}
+}
+
+// loadRetries queues the retries saved in retryFile by a previous run
+func loadRetries() error {
+	if retryFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(retryFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var stored []storedRetry
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	for _, sr := range stored {
+		item := &retryItem{zone: sr.Zone, attempts: sr.Attempts, tries: sr.Tries, next: sr.Next}
+		for _, su := range sr.Units {
+			unit := changeUnit{key: su.Key, changes: su.Changes}
+			if su.Route != nil {
+				unit.route = &Route{
+					subdomain: su.Route.Subdomain,
+					domain:    su.Route.Domain,
+					ips:       su.Route.Ips,
+					alias:     su.Route.Alias,
+					options:   su.Route.Options,
+					zone:      su.Route.Zone,
+				}
+			}
+			item.units = append(item.units, unit)
+		}
+		retries = append(retries, item)
+	}
+	if len(retries) != 0 {
+		sLog.Infof("Loaded %d queued retries from %s", len(retries), retryFile)
+	}
+	return nil
+}
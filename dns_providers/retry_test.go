@@ -0,0 +1,132 @@
+package dns_providers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"go.uber.org/zap"
+)
+
+func TestIsThrottled(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{awserr.New("Throttling", "Rate exceeded", nil), true},
+		{awserr.New("InvalidChangeBatch", "Tried to create an existing record", nil), false},
+		{fmt.Errorf("webhook returned 429 Too Many Requests: slow down"), true},
+		{fmt.Errorf("POST https://dns.googleapis.com/dns/v1/projects/p/managedZones/z/changes returned 403 Forbidden: rateLimitExceeded"), true},
+		{fmt.Errorf("webhook returned 500 Internal Server Error: boom"), false},
+	}
+	for _, test := range tests {
+		if got := isThrottled(test.err); got != test.want {
+			t.Errorf("isThrottled(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestThrottledRetriesAreCapped(t *testing.T) {
+	SetProvider(NewMemoryProvider("example.com"), false, zap.NewNop().Sugar())
+	item := &retryItem{zone: Zone{ID: "example.com", Name: "example.com."}, units: []changeUnit{{key: "test/web"}}}
+	throttled := fmt.Errorf("webhook returned 429 Too Many Requests")
+	tries := 0
+	for item.failed(throttled) {
+		tries++
+		if tries > throttledTriesFactor*maxAttempts {
+			t.Fatal("a throttled change is retried forever")
+		}
+	}
+	if item.attempts != 0 {
+		t.Errorf("throttled failures counted %d attempts", item.attempts)
+	}
+}
+
+func TestRetriesSurviveRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SetProvider(NewMemoryProvider("example.com"), false, zap.NewNop().Sugar())
+	retryFile = filepath.Join(dir, "retries.json")
+	zone := Zone{ID: "example.com", Name: "example.com."}
+	route := Route{
+		subdomain: "web.example.com",
+		domain:    "example.com.",
+		ips:       []string{"10.0.0.1"},
+		options:   RouteOptions{Resource: "ingress/default/web"},
+		zone:      zone,
+	}
+	unit := changeUnit{
+		key:     "test/web.example.com",
+		changes: []Change{{Action: ActionUpsert, Record: newRecord("web.example.com", route.ips, "", route.options)}},
+		route:   &route,
+	}
+	queueRetry(zone, []changeUnit{unit}, fmt.Errorf("webhook returned 500 Internal Server Error"))
+	saved := retries[0]
+
+	// a new process starts with an empty queue
+	retries = nil
+	if err := loadRetries(); err != nil {
+		t.Fatal(err)
+	}
+	if len(retries) != 1 {
+		t.Fatalf("loaded %d retries, want 1", len(retries))
+	}
+	loaded := retries[0]
+	if loaded.zone != saved.zone || loaded.attempts != saved.attempts || loaded.tries != saved.tries || !loaded.next.Equal(saved.next) {
+		t.Errorf("loaded retry %+v, want %+v", loaded, saved)
+	}
+	if !reflect.DeepEqual(loaded.units, saved.units) {
+		t.Errorf("loaded units %+v, want %+v", loaded.units, saved.units)
+	}
+
+	// the file follows the queue once the retry is superseded
+	dropRetries(func(zone Zone, unit changeUnit) bool { return true })
+	retries = nil
+	if err := loadRetries(); err != nil {
+		t.Fatal(err)
+	}
+	if len(retries) != 0 {
+		t.Errorf("loaded %d retries after dropping them, want none", len(retries))
+	}
+}
+
+func TestUpsertOnlyReconcileKeepsQueuedRemovals(t *testing.T) {
+	memory := NewMemoryProvider("example.com")
+	SetProvider(memory, false, zap.NewNop().Sugar())
+	policy = PolicyUpsertOnly
+	zone := Zone{ID: "example.com", Name: "example.com."}
+	old := Record{Name: "old.example.com", Type: "A", TTL: 300, Targets: []string{"10.0.0.2"}}
+	web := Record{Name: "web.example.com", Type: "A", TTL: 300, Targets: []string{"10.0.0.3"}}
+	// the removals of old, no longer wanted, and of web, wanted again,
+	// failed before, as loaded from --retry-file
+	removal := func(record Record) changeUnit {
+		return changeUnit{key: "test/" + record.Name, changes: []Change{{Action: ActionDelete, Record: record}}}
+	}
+	queueRetry(zone, []changeUnit{removal(old), removal(web)}, fmt.Errorf("webhook returned 500 Internal Server Error"))
+
+	desired := []Route{NewRoute("web.example.com", []string{"10.0.0.1"}, "", RouteOptions{})}
+	if _, err := Reconcile("test", desired); err != nil {
+		t.Fatal(err)
+	}
+	if len(retries) != 1 || len(retries[0].units) != 1 || retries[0].units[0].key != "test/old.example.com" {
+		t.Errorf("queued retries = %v, want only the removal of old.example.com", retries)
+	}
+
+	// the sync policy deletes the records no longer wanted itself
+	policy = PolicySync
+	if _, err := Reconcile("test", desired); err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range retries {
+		if len(item.units) != 0 {
+			t.Errorf("queued units = %v, want none with the sync policy", item.units)
+		}
+	}
+}
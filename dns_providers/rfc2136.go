@@ -0,0 +1,226 @@
+package dns_providers
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func init() {
+	registerProvider("rfc2136", newRFC2136Provider)
+}
+
+// rfc2136Provider is the DNSProvider sending RFC 2136 dynamic updates to a
+// self-hosted authoritative server (BIND, Knot, PowerDNS...). Every batch
+// of changes is sent in a single update message, which the server applies
+// atomically. Records are listed with a zone transfer (AXFR), and the zone
+// a domain belongs to is found with a SOA query. Alias routes are published
+// as CNAMEs.
+type rfc2136Provider struct {
+	server     string
+	zones      []string
+	tsigKey    string
+	tsigAlgo   string
+	tsigSecret map[string]string
+}
+
+// newRFC2136Provider creates the rfc2136 provider, the base64 TSIG secret
+// of the configured key is read from the RFC2136_TSIG_SECRET environment
+// variable.
+func newRFC2136Provider(config Config) (DNSProvider, error) {
+	if config.RFC2136Server == "" {
+		return nil, fmt.Errorf("a server is needed for the rfc2136 provider")
+	}
+	p := &rfc2136Provider{
+		server:   config.RFC2136Server,
+		zones:    config.RFC2136Zones,
+		tsigAlgo: dns.HmacSHA256,
+	}
+	if config.RFC2136TSIGAlgorithm != "" {
+		p.tsigAlgo = dns.Fqdn(config.RFC2136TSIGAlgorithm)
+	}
+	if config.RFC2136TSIGKey != "" {
+		secret := os.Getenv("RFC2136_TSIG_SECRET")
+		if secret == "" {
+			return nil, fmt.Errorf("RFC2136_TSIG_SECRET is needed to sign updates with key %s", config.RFC2136TSIGKey)
+		}
+		p.tsigKey = dns.Fqdn(strings.ToLower(config.RFC2136TSIGKey))
+		p.tsigSecret = map[string]string{p.tsigKey: secret}
+	}
+	return p, nil
+}
+
+// Zones returns the configured zones the server is authoritative for
+func (p *rfc2136Provider) Zones() ([]Zone, error) {
+	zones := make([]Zone, 0, len(p.zones))
+	for _, name := range p.zones {
+		zone, err := p.ZoneFor(name)
+		if err != nil {
+			return nil, err
+		}
+		if zone.Name != normalizeName(name) {
+			return nil, fmt.Errorf("%s is not a zone, it belongs to zone %s", name, zone.Name)
+		}
+		zones = append(zones, *zone)
+	}
+	return zones, nil
+}
+
+// ZoneFor queries the SOA of domain, the server answers with the SOA of
+// the zone domain belongs to either as the answer or in the authority
+// section.
+func (p *rfc2136Provider) ZoneFor(domain string) (*Zone, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(normalizeName(domain), dns.TypeSOA)
+	in, err := p.exchange(m)
+	if err != nil {
+		return nil, fmt.Errorf("SOA query for %s failed: %v", domain, err)
+	}
+	for _, rr := range append(in.Answer, in.Ns...) {
+		if soa, ok := rr.(*dns.SOA); ok {
+			name := normalizeName(soa.Hdr.Name)
+			return &Zone{ID: name, Name: name}, nil
+		}
+	}
+	return nil, fmt.Errorf("No zone found for %s", domain)
+}
+
+func (p *rfc2136Provider) Records(zone Zone) ([]Record, error) {
+	m := new(dns.Msg)
+	m.SetAxfr(normalizeName(zone.Name))
+	p.sign(m)
+	t := &dns.Transfer{TsigSecret: p.tsigSecret}
+	envelopes, err := t.In(m, p.server)
+	if err != nil {
+		return nil, fmt.Errorf("Zone transfer of %s failed: %v", zone.Name, err)
+	}
+	// group the resource records sharing name and type into record sets
+	sets := make(map[string]*Record)
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			return nil, fmt.Errorf("Zone transfer of %s failed: %v", zone.Name, envelope.Error)
+		}
+		for _, rr := range envelope.RR {
+			hdr := rr.Header()
+			record := Record{
+				Name: normalizeName(hdr.Name),
+				Type: dns.TypeToString[hdr.Rrtype],
+				TTL:  int64(hdr.Ttl),
+			}
+			key := recordKey(record)
+			set, ok := sets[key]
+			if !ok {
+				set = &record
+				sets[key] = set
+			}
+			value := strings.TrimPrefix(rr.String(), hdr.String())
+			// the SOA record starts and ends the transfer
+			if rr.Header().Rrtype == dns.TypeSOA && len(set.Targets) != 0 {
+				continue
+			}
+			set.Targets = append(set.Targets, value)
+		}
+	}
+	records := make([]Record, 0, len(sets))
+	for _, set := range sets {
+		records = append(records, *set)
+	}
+	sort.Slice(records, func(i, j int) bool { return recordKey(records[i]) < recordKey(records[j]) })
+	return records, nil
+}
+
+func (p *rfc2136Provider) ApplyChanges(zone Zone, changes []Change) error {
+	m := new(dns.Msg)
+	m.SetUpdate(normalizeName(zone.Name))
+	for _, change := range changes {
+		rrs, err := toRRs(change.Record)
+		if err != nil {
+			return err
+		}
+		name := normalizeName(change.Record.Name)
+		switch change.Action {
+		case ActionCreate:
+			m.RRsetNotUsed(rrs[:1])
+			m.Insert(rrs)
+		case ActionUpsert:
+			// a name can either have a CNAME or A records, remove both
+			rrtype := rrs[0].Header().Rrtype
+			if rrtype == dns.TypeA || rrtype == dns.TypeCNAME {
+				m.RemoveRRset([]dns.RR{
+					&dns.ANY{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET}},
+					&dns.ANY{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET}},
+				})
+			} else {
+				m.RemoveRRset(rrs[:1])
+			}
+			m.Insert(rrs)
+		case ActionDelete:
+			// the record must exist with exactly these values, Used and
+			// Remove both change the headers so each gets its own copy
+			prereqs := make([]dns.RR, 0, len(rrs))
+			for _, rr := range rrs {
+				prereqs = append(prereqs, dns.Copy(rr))
+			}
+			m.Used(prereqs)
+			m.Remove(rrs)
+		default:
+			return fmt.Errorf("Unknown change action %s", change.Action)
+		}
+	}
+	p.sign(m)
+	in, err := p.exchange(m)
+	if err != nil {
+		return fmt.Errorf("Update of zone %s failed: %v", zone.Name, err)
+	}
+	if in.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("Update of zone %s failed: %s", zone.Name, dns.RcodeToString[in.Rcode])
+	}
+	return nil
+}
+
+func (p *rfc2136Provider) sign(m *dns.Msg) {
+	if p.tsigKey != "" {
+		m.SetTsig(p.tsigKey, p.tsigAlgo, 300, time.Now().Unix())
+	}
+}
+
+func (p *rfc2136Provider) exchange(m *dns.Msg) (*dns.Msg, error) {
+	c := &dns.Client{
+		Net:        "tcp",
+		TsigSecret: p.tsigSecret,
+	}
+	in, _, err := c.Exchange(m, p.server)
+	return in, err
+}
+
+// toRRs converts a record set to resource records, alias records become a
+// CNAME pointing to the load balancer
+func toRRs(record Record) ([]dns.RR, error) {
+	name := normalizeName(record.Name)
+	recordType := record.Type
+	targets := record.Targets
+	if record.Alias != "" {
+		recordType = "CNAME"
+		targets = []string{dns.Fqdn(record.Alias)}
+	}
+	ttl := record.TTL
+	if ttl == 0 {
+		ttl = 300
+	}
+	rrs := make([]dns.RR, 0, len(targets))
+	for _, target := range targets {
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, ttl, recordType, target))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s record %s: %v", recordType, name, err)
+		}
+		rrs = append(rrs, rr)
+	}
+	if len(rrs) == 0 {
+		return nil, fmt.Errorf("%s record %s has no values", recordType, name)
+	}
+	return rrs, nil
+}
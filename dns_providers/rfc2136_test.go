@@ -0,0 +1,242 @@
+package dns_providers
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const testTSIGKey = "update-key."
+const testTSIGSecret = "c2VjcmV0LXNlY3JldC1zZWNyZXQ="
+
+// fakeAuthServer is an authoritative server of a single zone over TCP
+// answering SOA queries and zone transfers, and applying the dynamic
+// updates signed with testTSIGKey
+type fakeAuthServer struct {
+	sync.Mutex
+	zone string
+	rrs  []dns.RR
+}
+
+// serve answers the length prefixed messages of a TCP connection, it
+// checks and adds the signatures itself to work with any version of the
+// dns package
+func (f *fakeAuthServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		r := new(dns.Msg)
+		if err := r.Unpack(buf); err != nil {
+			return
+		}
+		m := f.reply(r, buf)
+		out, err := m.Pack()
+		if tsig := r.IsTsig(); tsig != nil && m.Rcode != dns.RcodeNotAuth {
+			m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+			out, _, err = dns.TsigGenerate(m, testTSIGSecret, tsig.MAC, false)
+		}
+		if err != nil {
+			return
+		}
+		binary.BigEndian.PutUint16(length[:], uint16(len(out)))
+		if _, err := conn.Write(append(length[:], out...)); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeAuthServer) reply(r *dns.Msg, buf []byte) *dns.Msg {
+	f.Lock()
+	defer f.Unlock()
+	m := new(dns.Msg)
+	m.SetReply(r)
+	tsig := r.IsTsig()
+	if tsig != nil && (tsig.Hdr.Name != testTSIGKey || dns.TsigVerify(buf, testTSIGSecret, "", false) != nil) {
+		m.Rcode = dns.RcodeNotAuth
+		return m
+	}
+	soa, _ := dns.NewRR(f.zone + " 300 IN SOA ns1." + f.zone + " admin." + f.zone + " 1 3600 600 86400 300")
+	name := r.Question[0].Name
+	switch {
+	case r.Opcode == dns.OpcodeUpdate && tsig == nil:
+		m.Rcode = dns.RcodeRefused
+	case r.Opcode == dns.OpcodeUpdate:
+		m.Rcode = f.update(r)
+	case !dns.IsSubDomain(f.zone, name):
+		m.Rcode = dns.RcodeRefused
+	case r.Question[0].Qtype == dns.TypeAXFR:
+		m.Answer = append(append([]dns.RR{soa}, f.rrs...), soa)
+	case r.Question[0].Qtype == dns.TypeSOA && name == f.zone:
+		m.Answer = []dns.RR{soa}
+	case r.Question[0].Qtype == dns.TypeSOA:
+		m.Ns = []dns.RR{soa}
+	}
+	return m
+}
+
+// update checks the prerequisites of r, then applies its updates at once
+func (f *fakeAuthServer) update(r *dns.Msg) int {
+	// the RRsets of the value dependent prerequisites must be exactly the
+	// RRsets of the zone (RFC 2136 3.2.3)
+	sizes := make(map[dns.RR_Header]int)
+	for _, prereq := range r.Answer {
+		hdr := prereq.Header()
+		switch hdr.Class {
+		case dns.ClassNONE:
+			for _, rr := range f.rrs {
+				if sameRRset(rr, hdr) {
+					return dns.RcodeYXRrset
+				}
+			}
+		default:
+			if f.find(prereq) < 0 {
+				return dns.RcodeNXRrset
+			}
+			sizes[dns.RR_Header{Name: dns.Fqdn(hdr.Name), Rrtype: hdr.Rrtype}]++
+		}
+	}
+	for set, size := range sizes {
+		for _, rr := range f.rrs {
+			if sameRRset(rr, &set) {
+				size--
+			}
+		}
+		if size != 0 {
+			return dns.RcodeNXRrset
+		}
+	}
+	for _, update := range r.Ns {
+		hdr := update.Header()
+		switch hdr.Class {
+		case dns.ClassANY:
+			rrs := f.rrs[:0]
+			for _, rr := range f.rrs {
+				if !sameRRset(rr, hdr) {
+					rrs = append(rrs, rr)
+				}
+			}
+			f.rrs = rrs
+		case dns.ClassNONE:
+			if i := f.find(update); i >= 0 {
+				f.rrs = append(f.rrs[:i], f.rrs[i+1:]...)
+			}
+		default:
+			if f.find(update) < 0 {
+				f.rrs = append(f.rrs, update)
+			}
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+// find returns the index of the resource record with the data of rr
+func (f *fakeAuthServer) find(rr dns.RR) int {
+	for i, existing := range f.rrs {
+		a, b := dns.Copy(existing), dns.Copy(rr)
+		for _, hdr := range []*dns.RR_Header{a.Header(), b.Header()} {
+			hdr.Ttl = 0
+			hdr.Class = dns.ClassINET
+		}
+		if a.String() == b.String() {
+			return i
+		}
+	}
+	return -1
+}
+
+func sameRRset(rr dns.RR, hdr *dns.RR_Header) bool {
+	return dns.Fqdn(rr.Header().Name) == dns.Fqdn(hdr.Name) &&
+		(hdr.Rrtype == dns.TypeANY || rr.Header().Rrtype == hdr.Rrtype)
+}
+
+func init() {
+	contractBackends["rfc2136"] = func(t *testing.T) (DNSProvider, Zone, func()) {
+		provider, _, shutdown := newTestRFC2136Provider(t)
+		return provider, Zone{ID: "example.com.", Name: "example.com."}, shutdown
+	}
+}
+
+func newTestRFC2136Provider(t *testing.T) (DNSProvider, *fakeAuthServer, func()) {
+	fake := &fakeAuthServer{zone: "example.com."}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go fake.serve(conn)
+		}
+	}()
+	os.Setenv("RFC2136_TSIG_SECRET", testTSIGSecret)
+	defer os.Unsetenv("RFC2136_TSIG_SECRET")
+	provider, err := newRFC2136Provider(Config{
+		RFC2136Server:        l.Addr().String(),
+		RFC2136Zones:         []string{"example.com"},
+		RFC2136TSIGKey:       testTSIGKey,
+		RFC2136TSIGAlgorithm: dns.HmacSHA256,
+	})
+	if err != nil {
+		l.Close()
+		t.Fatal(err)
+	}
+	return provider, fake, func() { l.Close() }
+}
+
+func TestRFC2136Zones(t *testing.T) {
+	provider, _, shutdown := newTestRFC2136Provider(t)
+	defer shutdown()
+	zones, err := provider.Zones()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Zone{ID: "example.com.", Name: "example.com."}
+	if !reflect.DeepEqual(zones, []Zone{want}) {
+		t.Errorf("Zones() = %v, want [%v]", zones, want)
+	}
+	// the SOA of a name in the zone comes in the authority section
+	zone, err := provider.(ZoneFinder).ZoneFor("web.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*zone, want) {
+		t.Errorf("ZoneFor(web.example.com) = %v, want %v", *zone, want)
+	}
+}
+
+func TestRFC2136UpdateNeedsTheKey(t *testing.T) {
+	provider, fake, shutdown := newTestRFC2136Provider(t)
+	defer shutdown()
+	p := provider.(*rfc2136Provider)
+	change := []Change{
+		{Action: ActionUpsert, Record: Record{Name: "web.example.com", Type: "A", Targets: []string{"10.0.0.1"}}},
+	}
+	p.tsigSecret = map[string]string{testTSIGKey: "b3RoZXItc2VjcmV0"}
+	if err := provider.ApplyChanges(Zone{ID: "example.com.", Name: "example.com."}, change); err == nil {
+		t.Error("the server accepted an update signed with another secret")
+	}
+	p.tsigKey = ""
+	if err := provider.ApplyChanges(Zone{ID: "example.com.", Name: "example.com."}, change); err == nil {
+		t.Error("the server accepted an unsigned update")
+	}
+	if len(fake.rrs) != 0 {
+		t.Errorf("a refused update changed the zone: %v", fake.rrs)
+	}
+}
@@ -0,0 +1,171 @@
+package dns_providers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+var dryRun bool
+var routes Routes
+var sLog *zap.SugaredLogger
+
+// routesLock protects routes, they are only changed by the watch loop but
+// DumpRoutes is called by the debug server
+var routesLock sync.RWMutex
+
+type Route struct {
+	subdomain string
+	domain    string
+	ips       []string
+	alias     string
+	options   RouteOptions
+	zone      Zone
+}
+type Routes map[string]Route
+
+// RouteOptions are the optional settings of a route
+type RouteOptions struct {
+	// Proxied routes the traffic through the cloudflare proxy
+	Proxied bool
+	// Resource is the kubernetes resource needing the route (i.e.
+	// ingress/default/web), it is stored in the companion TXT record
+	Resource string
+	// TTL of the records, 300 when zero, alias records have none
+	TTL int64
+	// CNAME publishes the alias as a CNAME record instead of an alias
+	// record, for targets which are not load balancers
+	CNAME bool
+}
+
+// AddRoute creates or updates the records of subdomain on behalf of the
+// owner id. Records which already exist are only updated when id owns them.
+func AddRoute(id, subdomain *string, ips []string, alias string, options RouteOptions) error {
+	batch := NewBatch(*id)
+	if err := batch.AddRoute(*subdomain, ips, alias, options); err != nil {
+		return err
+	}
+	if err := batch.Apply(); err != nil {
+		return fmt.Errorf("Unable to update DNS for subdomain %s : %v", *subdomain, err)
+	}
+	return nil
+}
+
+// RemoveRoute deletes the records of subdomain, only the routes added by
+// AddRoute or Reconcile with the same id can be removed
+func RemoveRoute(id, subdomain *string, alias string) error {
+	batch := NewBatch(*id)
+	if err := batch.RemoveRoute(*subdomain, alias); err != nil {
+		return err
+	}
+	if err := batch.Apply(); err != nil {
+		return fmt.Errorf("Unable to delete DNS for subdomain %s: %v", *subdomain, err)
+	}
+	return nil
+}
+
+// RouteDump is a route managed in the DNS provider as shown by the debug
+// server
+type RouteDump struct {
+	Key       string   `json:"key"`
+	Subdomain string   `json:"subdomain"`
+	Zone      Zone     `json:"zone"`
+	Ips       []string `json:"ips,omitempty"`
+	Alias     string   `json:"alias,omitempty"`
+	Proxied   bool     `json:"proxied,omitempty"`
+	Resource  string   `json:"resource,omitempty"`
+}
+
+// DumpRoutes returns the routes currently managed sorted by key
+func DumpRoutes() []RouteDump {
+	routesLock.RLock()
+	defer routesLock.RUnlock()
+	dump := make([]RouteDump, 0, len(routes))
+	for key, route := range routes {
+		dump = append(dump, RouteDump{
+			Key:       key,
+			Subdomain: route.subdomain,
+			Zone:      route.zone,
+			Ips:       route.ips,
+			Alias:     route.alias,
+			Proxied:   route.options.Proxied,
+			Resource:  route.options.Resource,
+		})
+	}
+	sort.Slice(dump, func(i, j int) bool { return dump[i].Key < dump[j].Key })
+	return dump
+}
+
+// newRecord creates the A record pointing domain to either the alias or
+// the given ips
+func newRecord(domain string, ips []string, alias string, options RouteOptions) Record {
+	record := Record{
+		Name:    domainWithTrailingDot(domain),
+		Type:    "A",
+		Proxied: options.Proxied,
+	}
+	ttl := options.TTL
+	if ttl == 0 {
+		ttl = 300
+	}
+	// If we have an alias we use that
+	if alias != "" && options.CNAME {
+		record.Type = "CNAME"
+		record.TTL = ttl
+		record.Targets = []string{alias}
+	} else if alias != "" {
+		record.Alias = alias
+	} else {
+		record.TTL = ttl
+		record.Targets = ips
+	}
+	return record
+}
+
+func getTLD(domain string) (string, error) {
+	domainParts := strings.Split(domain, ".")
+	segments := len(domainParts)
+	if segments < 3 {
+		return "", fmt.Errorf(
+			"Domain %s is invalid - it should be a fully qualified domain name and subdomain (i.e. test.example.com)",
+			domain)
+	}
+	return strings.Join(domainParts[segments-2:], "."), nil
+}
+
+func findMostSpecificZoneForDomain(domain string, zones []Zone) (*Zone, error) {
+	domain = domainWithTrailingDot(domain)
+	if len(zones) < 1 {
+		return nil, fmt.Errorf("No zone found for %s", domain)
+	}
+	var mostSpecific *Zone
+	curLen := 0
+
+	for i := range zones {
+		zone := &zones[i]
+		zoneName := zone.Name
+		if dryRun {
+			sLog.Infof("domain: %v checking %v", domain, zoneName)
+		}
+		if (domain == zoneName || strings.HasSuffix(domain, "."+zoneName)) && curLen < len(zoneName) {
+			curLen = len(zoneName)
+			mostSpecific = zone
+		}
+	}
+
+	if mostSpecific == nil {
+		return nil, fmt.Errorf("Zone found %s does not match domain given %s", zones[0].Name, domain)
+	}
+
+	return mostSpecific, nil
+}
+
+func domainWithTrailingDot(withoutDot string) string {
+	if withoutDot == "" || strings.HasSuffix(withoutDot, ".") {
+		return withoutDot
+	}
+	return fmt.Sprint(withoutDot, ".")
+}
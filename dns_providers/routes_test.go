@@ -0,0 +1,19 @@
+package dns_providers
+
+import "testing"
+
+func TestDomainWithTrailingDot(t *testing.T) {
+	tests := map[string]string{
+		"":                 "",
+		".":                ".",
+		"example.com":      "example.com.",
+		"example.com.":     "example.com.",
+		"*.example.com":    "*.example.com.",
+		"web.example.com.": "web.example.com.",
+	}
+	for domain, want := range tests {
+		if got := domainWithTrailingDot(domain); got != want {
+			t.Errorf("domainWithTrailingDot(%q) = %q, want %q", domain, got, want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package dns_providers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const webhookTimeout = 30 * time.Second
+
+func init() {
+	registerProvider("webhook", newWebhookProvider)
+}
+
+// webhookProvider is the DNSProvider delegating to an out-of-process
+// provider (usually a sidecar) speaking the webhook provider protocol
+// documented in docs/webhook-provider.md. NewWebhookHandler implements the
+// server side of the protocol.
+type webhookProvider struct {
+	client *http.Client
+	url    string
+	// limits are asked once to the webhook
+	limitsLock sync.Mutex
+	limits     *BatchLimits
+}
+
+// webhookChanges is the body of a POST /changes request
+type webhookChanges struct {
+	Zone    Zone     `json:"zone"`
+	Changes []Change `json:"changes"`
+}
+
+// webhookError is the body of every failed webhook request
+type webhookError struct {
+	Error string `json:"error"`
+}
+
+// webhookStatusError is a webhook response outside of the 2xx range
+type webhookStatusError struct {
+	status int
+	msg    string
+}
+
+func (e webhookStatusError) Error() string {
+	return e.msg
+}
+
+func newWebhookProvider(config Config) (DNSProvider, error) {
+	if config.WebhookURL == "" {
+		return nil, fmt.Errorf("a URL is needed for the webhook provider")
+	}
+	return &webhookProvider{
+		client: &http.Client{Timeout: webhookTimeout},
+		url:    strings.TrimSuffix(config.WebhookURL, "/"),
+	}, nil
+}
+
+func (p *webhookProvider) Zones() ([]Zone, error) {
+	var zones []Zone
+	if err := p.do("GET", "/zones", nil, &zones); err != nil {
+		return nil, fmt.Errorf("Failed to list zones: %v", err)
+	}
+	return zones, nil
+}
+
+func (p *webhookProvider) Records(zone Zone) ([]Record, error) {
+	query := url.Values{"zoneId": {zone.ID}, "zoneName": {zone.Name}}
+	var records []Record
+	if err := p.do("GET", "/records?"+query.Encode(), nil, &records); err != nil {
+		return nil, fmt.Errorf("Failed to list records in zone %s: %v", zone.Name, err)
+	}
+	return records, nil
+}
+
+func (p *webhookProvider) ApplyChanges(zone Zone, changes []Change) error {
+	return p.do("POST", "/changes", webhookChanges{Zone: zone, Changes: changes}, nil)
+}
+
+// BatchLimits asks the webhook for the limits of a POST /changes once, the
+// webhooks not serving GET /limits have none
+func (p *webhookProvider) BatchLimits() BatchLimits {
+	p.limitsLock.Lock()
+	defer p.limitsLock.Unlock()
+	if p.limits != nil {
+		return *p.limits
+	}
+	var limits BatchLimits
+	err := p.do("GET", "/limits", nil, &limits)
+	if statusErr, ok := err.(webhookStatusError); ok && statusErr.status == http.StatusNotFound {
+		limits, err = BatchLimits{}, nil
+	}
+	if err != nil {
+		// asked again before the next changes
+		sLog.Warnf("Failed to get the batch limits of the webhook, sending the changes at once: %v", err)
+		return BatchLimits{}
+	}
+	p.limits = &limits
+	return limits
+}
+
+// do sends a request to the webhook, and decodes the JSON response into out
+func (p *webhookProvider) do(method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, p.url+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		var webhookErr webhookError
+		if json.Unmarshal(msg, &webhookErr) != nil || webhookErr.Error == "" {
+			webhookErr.Error = strings.TrimSpace(string(msg))
+		}
+		return webhookStatusError{
+			status: resp.StatusCode,
+			msg:    fmt.Sprintf("webhook returned %s: %s", resp.Status, webhookErr.Error),
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,84 @@
+package dns_providers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// NewWebhookHandler serves the webhook provider protocol on top of p, so
+// any DNSProvider can be run out-of-process as a sidecar of the
+// controller. The limits of p are served when it is a ChangeLimiter.
+func NewWebhookHandler(p DNSProvider) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/zones", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeWebhookError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+			return
+		}
+		zones, err := p.Zones()
+		if err != nil {
+			writeWebhookError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		writeWebhookJSON(w, zones)
+	})
+	mux.HandleFunc("/records", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeWebhookError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+			return
+		}
+		zone := Zone{
+			ID:   r.URL.Query().Get("zoneId"),
+			Name: r.URL.Query().Get("zoneName"),
+		}
+		if zone.ID == "" || zone.Name == "" {
+			writeWebhookError(w, http.StatusBadRequest, "zoneId and zoneName are required")
+			return
+		}
+		records, err := p.Records(zone)
+		if err != nil {
+			writeWebhookError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		writeWebhookJSON(w, records)
+	})
+	mux.HandleFunc("/changes", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			writeWebhookError(w, http.StatusMethodNotAllowed, "only POST is allowed")
+			return
+		}
+		var changes webhookChanges
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			writeWebhookError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := p.ApplyChanges(changes.Zone, changes.Changes); err != nil {
+			writeWebhookError(w, http.StatusConflict, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/limits", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeWebhookError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+			return
+		}
+		var limits BatchLimits
+		if limiter, ok := p.(ChangeLimiter); ok {
+			limits = limiter.BatchLimits()
+		}
+		writeWebhookJSON(w, limits)
+	})
+	return mux
+}
+
+func writeWebhookJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeWebhookError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(webhookError{Error: msg})
+}
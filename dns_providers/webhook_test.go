@@ -0,0 +1,189 @@
+package dns_providers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// limitedProvider is a memory provider with batch limits
+type limitedProvider struct {
+	*MemoryProvider
+	limits BatchLimits
+}
+
+func (p limitedProvider) BatchLimits() BatchLimits {
+	return p.limits
+}
+
+// newTestWebhookProvider serves p through the webhook provider protocol
+// and returns the webhook provider using it
+func newTestWebhookProvider(t *testing.T, p DNSProvider) (*webhookProvider, *httptest.Server) {
+	server := httptest.NewServer(NewWebhookHandler(p))
+	provider, err := newWebhookProvider(Config{WebhookURL: server.URL + "/"})
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return provider.(*webhookProvider), server
+}
+
+func init() {
+	contractBackends["webhook"] = func(t *testing.T) (DNSProvider, Zone, func()) {
+		provider, server := newTestWebhookProvider(t, NewMemoryProvider("example.com"))
+		return provider, Zone{ID: "example.com", Name: "example.com."}, server.Close
+	}
+}
+
+func TestWebhookChangesReplyNoContent(t *testing.T) {
+	_, server := newTestWebhookProvider(t, NewMemoryProvider("example.com"))
+	defer server.Close()
+	body := `{"zone": {"id": "example.com", "name": "example.com."}, "changes": [` +
+		`{"action": "CREATE", "record": {"name": "web.example.com.", "type": "A", "ttl": 300, "targets": ["10.0.0.1"]}}]}`
+	resp, err := http.Post(server.URL+"/changes", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	content, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusNoContent || len(content) != 0 {
+		t.Errorf("reply %s %q, want 204 No Content", resp.Status, content)
+	}
+}
+
+func TestWebhookErrors(t *testing.T) {
+	provider, server := newTestWebhookProvider(t, NewMemoryProvider("example.com"))
+	defer server.Close()
+	zone := Zone{ID: "example.com", Name: "example.com."}
+	missing := Record{Name: "web.example.com.", Type: "A", TTL: 300, Targets: []string{"10.0.0.1"}}
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			"refused changes",
+			provider.ApplyChanges(zone, []Change{{Action: ActionDelete, Record: missing}}),
+			"webhook returned 409 Conflict: ",
+		},
+		{
+			"malformed request",
+			func() error { _, err := provider.Records(Zone{}); return err }(),
+			"webhook returned 400 Bad Request: zoneId and zoneName are required",
+		},
+		{
+			"wrong method",
+			provider.do("PUT", "/zones", nil, nil),
+			"webhook returned 405 Method Not Allowed: only GET is allowed",
+		},
+	}
+	for _, test := range tests {
+		if test.err == nil || !strings.Contains(test.err.Error(), test.want) {
+			t.Errorf("%s: error %v, want %q", test.name, test.err, test.want)
+		}
+	}
+
+	// the bodies which are not a webhook error are returned as is
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "backend down", http.StatusBadGateway)
+	}))
+	defer plain.Close()
+	provider.url = plain.URL
+	want := "Failed to list zones: webhook returned 502 Bad Gateway: backend down"
+	if _, err := provider.Zones(); err == nil || err.Error() != want {
+		t.Errorf("error %v, want %q", err, want)
+	}
+}
+
+func TestWebhookBatchLimits(t *testing.T) {
+	limits := BatchLimits{MaxRecords: route53MaxRecords, MaxChars: route53MaxChars}
+	provider, server := newTestWebhookProvider(t, limitedProvider{NewMemoryProvider("example.com"), limits})
+	if got := provider.BatchLimits(); got != limits {
+		t.Errorf("BatchLimits() = %v, want %v", got, limits)
+	}
+	// the limits are only asked once
+	server.Close()
+	if got := provider.BatchLimits(); got != limits {
+		t.Errorf("BatchLimits() after the first call = %v, want %v", got, limits)
+	}
+
+	// a provider without limits, and a webhook without the endpoint, have
+	// none
+	provider, server = newTestWebhookProvider(t, NewMemoryProvider("example.com"))
+	defer server.Close()
+	if got := provider.BatchLimits(); got != (BatchLimits{}) {
+		t.Errorf("BatchLimits() of the memory provider = %v, want no limit", got)
+	}
+	old := httptest.NewServer(http.NotFoundHandler())
+	defer old.Close()
+	provider.url = old.URL
+	provider.limits = nil
+	if got := provider.BatchLimits(); got != (BatchLimits{}) || provider.limits == nil {
+		t.Errorf("BatchLimits() without GET /limits = %v, want no limit", got)
+	}
+}
+
+func TestBatchLimitsFits(t *testing.T) {
+	limits := BatchLimits{MaxRecords: 4, MaxChars: 40}
+	address := func(action string, targets ...string) Change {
+		return Change{Action: action, Record: Record{Name: "web.example.com.", Type: "A", TTL: 300, Targets: targets}}
+	}
+	tests := []struct {
+		name    string
+		changes []Change
+		fits    bool
+	}{
+		{"no change", nil, true},
+		{"at the record limit", []Change{address(ActionCreate, "10.0.0.1", "10.0.0.2"), address(ActionDelete, "10.0.0.3", "10.0.0.4")}, true},
+		{"over the record limit", []Change{address(ActionCreate, "10.0.0.1", "10.0.0.2", "10.0.0.3"), address(ActionDelete, "10.0.0.4", "10.0.0.5")}, false},
+		{"upserts count twice", []Change{address(ActionUpsert, "10.0.0.1", "10.0.0.2", "10.0.0.3")}, false},
+		{"over the character limit", []Change{{Action: ActionUpsert, Record: Record{Name: "web.example.com.", Type: "A", Alias: "dualstack.lb-1234.elb.amazonaws.com"}}}, false},
+	}
+	for _, test := range tests {
+		if fits := limits.Fits(test.changes); fits != test.fits {
+			t.Errorf("%s: Fits() = %v, want %v", test.name, fits, test.fits)
+		}
+	}
+	if !(BatchLimits{}).Fits([]Change{address(ActionUpsert, "10.0.0.1", "10.0.0.2", "10.0.0.3")}) {
+		t.Error("a batch does not fit without limits")
+	}
+}
+
+// TestWebhookSplitsLargeBatches checks the changes sent through a webhook
+// respect the limits it serves
+func TestWebhookSplitsLargeBatches(t *testing.T) {
+	var sizes []int
+	memory := NewMemoryProvider("example.com")
+	handler := NewWebhookHandler(limitedProvider{memory, BatchLimits{MaxRecords: 4}})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/changes" {
+			body, _ := ioutil.ReadAll(r.Body)
+			sizes = append(sizes, bytes.Count(body, []byte(`"action"`)))
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	provider, err := newWebhookProvider(Config{WebhookURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone := Zone{ID: "example.com", Name: "example.com."}
+	units := make([]changeUnit, 0, 3)
+	for _, name := range []string{"a", "b", "c"} {
+		record := Record{Name: name + ".example.com.", Type: "A", TTL: 300, Targets: []string{"10.0.0.1"}}
+		units = append(units, changeUnit{key: name, changes: []Change{{Action: ActionCreate, Record: record}, {Action: ActionCreate, Record: ownerRecord(record.Name, "test", "")}}})
+	}
+	SetProvider(provider, false, zap.NewNop().Sugar())
+	if _, _, err := applyUnits(zone, units); err != nil {
+		t.Fatal(err)
+	}
+	if len(sizes) != 2 || sizes[0] != 4 || sizes[1] != 2 {
+		t.Errorf("changes sent per call %v, want [4 2]", sizes)
+	}
+}
@@ -0,0 +1,209 @@
+package dns_server
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/miekg/dns"
+
+	"github.com/victor-fdez/kube-route53-traefik/view"
+)
+
+const ttl = 300
+
+var addr string
+var zones []string
+var nameserver string
+var serial uint32
+var sLog *zap.SugaredLogger
+
+// routeIndex holds the routes of the view by name, it is rebuilt on the
+// first query after the view changed
+var routeIndex struct {
+	sync.Mutex
+	generation uint64
+	built      bool
+	routes     map[string]view.Route
+}
+
+// Setup configures the authoritative DNS server answering for zones on
+// addr straight from view.State. nameserver is the name of this server
+// returned in the NS and SOA records, it defaults to ns1.<zone>.
+func Setup(Addr string, Zones []string, Nameserver string, SLog *zap.SugaredLogger) {
+	addr = Addr
+	zones = make([]string, 0, len(Zones))
+	for _, zone := range Zones {
+		zones = append(zones, dns.Fqdn(strings.ToLower(zone)))
+	}
+	nameserver = Nameserver
+	if nameserver != "" {
+		nameserver = dns.Fqdn(nameserver)
+	}
+	serial = uint32(time.Now().Unix())
+	sLog = SLog
+}
+
+// Start serves DNS over both UDP and TCP in the background
+func Start() {
+	handler := dns.HandlerFunc(handle)
+	for _, network := range []string{"udp", "tcp"} {
+		server := &dns.Server{Addr: addr, Net: network, Handler: handler}
+		go func() {
+			sLog.Infof("Serving DNS for zones %v on %s/%s", zones, server.Addr, server.Net)
+			if err := server.ListenAndServe(); err != nil {
+				sLog.Panic(err)
+			}
+		}()
+	}
+}
+
+func handle(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	defer w.WriteMsg(m)
+	if len(r.Question) != 1 {
+		m.Rcode = dns.RcodeFormatError
+		return
+	}
+	q := r.Question[0]
+	name := strings.ToLower(q.Name)
+	zone := findZone(name)
+	if zone == "" {
+		m.Rcode = dns.RcodeRefused
+		return
+	}
+	m.Authoritative = true
+	if name == zone {
+		switch q.Qtype {
+		case dns.TypeSOA:
+			m.Answer = append(m.Answer, soa(zone))
+		case dns.TypeNS:
+			m.Answer = append(m.Answer, ns(zone))
+		}
+	}
+	route, found := findRoute(name, zone)
+	if found {
+		// the answers keep the case of the question
+		m.Answer = append(m.Answer, answer(q.Name, q.Qtype, route)...)
+	} else if name != zone {
+		m.Rcode = dns.RcodeNameError
+	}
+	// negative answers carry the SOA to be cached
+	if len(m.Answer) == 0 {
+		m.Ns = append(m.Ns, soa(zone))
+	}
+}
+
+// answer returns the records of route matching qtype, alias routes are
+// answered with a CNAME whatever the type asked
+func answer(name string, qtype uint16, route view.Route) []dns.RR {
+	recordTTL := uint32(ttl)
+	if route.TTL > 0 {
+		recordTTL = uint32(route.TTL)
+	}
+	hdr := func(rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: recordTTL}
+	}
+	if route.Alias != "" {
+		return []dns.RR{&dns.CNAME{Hdr: hdr(dns.TypeCNAME), Target: dns.Fqdn(route.Alias)}}
+	}
+	rrs := make([]dns.RR, 0, len(route.Ips))
+	for _, value := range route.Ips {
+		ip := net.ParseIP(value)
+		switch {
+		case ip == nil:
+			sLog.Warnf("Ignoring invalid IP %s of %s", value, name)
+		case ip.To4() != nil && (qtype == dns.TypeA || qtype == dns.TypeANY):
+			rrs = append(rrs, &dns.A{Hdr: hdr(dns.TypeA), A: ip.To4()})
+		case ip.To4() == nil && (qtype == dns.TypeAAAA || qtype == dns.TypeANY):
+			rrs = append(rrs, &dns.AAAA{Hdr: hdr(dns.TypeAAAA), AAAA: ip})
+		}
+	}
+	return rrs
+}
+
+// findZone returns the most specific zone name belongs to, or an empty
+// string when we are not authoritative for it
+func findZone(name string) string {
+	mostSpecific := ""
+	for _, zone := range zones {
+		if (name == zone || strings.HasSuffix(name, "."+zone)) && len(zone) > len(mostSpecific) {
+			mostSpecific = zone
+		}
+	}
+	return mostSpecific
+}
+
+// findRoute returns the route of name, or else of the closest wildcard
+// (i.e. *.example.com) covering name in zone
+func findRoute(name, zone string) (view.Route, bool) {
+	routes := indexedRoutes()
+	if route, ok := routes[name]; ok {
+		return route, true
+	}
+	for parent := name; parent != zone; {
+		i := strings.Index(parent, ".")
+		if i < 0 || i == len(parent)-1 {
+			break
+		}
+		parent = parent[i+1:]
+		if route, ok := routes["*."+parent]; ok {
+			return route, true
+		}
+	}
+	return view.Route{}, false
+}
+
+// indexedRoutes returns the routes by name, rebuilding the index when the
+// view changed since it was built
+func indexedRoutes() map[string]view.Route {
+	routeIndex.Lock()
+	defer routeIndex.Unlock()
+	generation := view.State.Generation()
+	if routeIndex.built && routeIndex.generation == generation {
+		return routeIndex.routes
+	}
+	routes := make(map[string]view.Route)
+	for _, route := range view.State.Routes() {
+		name := dns.Fqdn(strings.ToLower(route.Subdomain))
+		// the first route of a name is answered, like the DNS providers do
+		if _, ok := routes[name]; !ok {
+			routes[name] = route
+		}
+	}
+	routeIndex.routes = routes
+	routeIndex.generation = generation
+	routeIndex.built = true
+	return routes
+}
+
+func nameserverFor(zone string) string {
+	if nameserver != "" {
+		return nameserver
+	}
+	return "ns1." + zone
+}
+
+func soa(zone string) dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: ttl},
+		Ns:      nameserverFor(zone),
+		Mbox:    "hostmaster." + zone,
+		Serial:  serial,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  60,
+	}
+}
+
+func ns(zone string) dns.RR {
+	return &dns.NS{
+		Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: ttl},
+		Ns:  nameserverFor(zone),
+	}
+}
@@ -0,0 +1,106 @@
+package dns_server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/watch"
+
+	"github.com/victor-fdez/kube-route53-traefik/apis/networking"
+	"github.com/victor-fdez/kube-route53-traefik/view"
+)
+
+// startTestServer serves the view on a local UDP port and returns its
+// address
+func startTestServer(t *testing.T) (string, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(handle), NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	return pc.LocalAddr().String(), func() { server.Shutdown() }
+}
+
+func query(t *testing.T, addr, name string, qtype uint16) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	r, _, err := new(dns.Client).Exchange(m, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func ingress(hosts ...string) *networking.Ingress {
+	ing := &networking.Ingress{ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "web"}}
+	for _, host := range hosts {
+		ing.Spec.Rules = append(ing.Spec.Rules, networking.IngressRule{Host: host})
+	}
+	return ing
+}
+
+func node(ip string) *v1.Node {
+	node := &v1.Node{}
+	node.Status.NodeInfo.MachineID = "node-1"
+	node.Status.Addresses = []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: ip}}
+	return node
+}
+
+func TestServeRoutes(t *testing.T) {
+	log := zap.NewNop().Sugar()
+	view.Setup(log)
+	Setup("127.0.0.1:0", []string{"example.com"}, "", log)
+	addr, shutdown := startTestServer(t)
+	defer shutdown()
+	ing := ingress("web.example.com", "*.apps.example.com")
+	view.State.UpdateNode(node("10.0.0.1"), watch.Added)
+	view.State.UpdateIngress(ing, watch.Added)
+
+	tests := []struct {
+		name  string
+		rcode int
+		ip    string
+	}{
+		{"web.example.com.", dns.RcodeSuccess, "10.0.0.1"},
+		{"WEB.example.com.", dns.RcodeSuccess, "10.0.0.1"},
+		{"foo.apps.example.com.", dns.RcodeSuccess, "10.0.0.1"},
+		{"a.b.apps.example.com.", dns.RcodeSuccess, "10.0.0.1"},
+		{"apps.example.com.", dns.RcodeNameError, ""},
+		{"api.example.com.", dns.RcodeNameError, ""},
+		{"web.example.org.", dns.RcodeRefused, ""},
+	}
+	for _, test := range tests {
+		r := query(t, addr, test.name, dns.TypeA)
+		if r.Rcode != test.rcode {
+			t.Errorf("%s: rcode %s, want %s", test.name, dns.RcodeToString[r.Rcode], dns.RcodeToString[test.rcode])
+			continue
+		}
+		if test.ip == "" {
+			continue
+		}
+		if len(r.Answer) != 1 {
+			t.Errorf("%s: answer %v, want one A record", test.name, r.Answer)
+			continue
+		}
+		if a, ok := r.Answer[0].(*dns.A); !ok || a.A.String() != test.ip || a.Hdr.Name != test.name {
+			t.Errorf("%s: answer %v, want %s", test.name, r.Answer[0], test.ip)
+		}
+	}
+
+	// the answers follow the view
+	view.State.UpdateNode(node("10.0.0.2"), watch.Modified)
+	r := query(t, addr, "foo.apps.example.com.", dns.TypeA)
+	if len(r.Answer) != 1 || r.Answer[0].(*dns.A).A.String() != "10.0.0.2" {
+		t.Errorf("answer after the update %v, want 10.0.0.2", r.Answer)
+	}
+	view.State.UpdateIngress(ing, watch.Deleted)
+	if r := query(t, addr, "web.example.com.", dns.TypeA); r.Rcode != dns.RcodeNameError {
+		t.Errorf("rcode after the delete %s, want NXDOMAIN", dns.RcodeToString[r.Rcode])
+	}
+}
@@ -0,0 +1,98 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/victor-fdez/kube-route53-traefik/dns_providers"
+	"github.com/victor-fdez/kube-route53-traefik/metrics"
+	"github.com/victor-fdez/kube-route53-traefik/view"
+)
+
+// check is a named health check, it returns why it failed
+type check struct {
+	name  string
+	check func() error
+}
+
+// state is the document served by /debug/state
+type state struct {
+	View   view.Dump                 `json:"view"`
+	Routes []dns_providers.RouteDump `json:"routes"`
+}
+
+var addr string
+var liveness, readiness []check
+var sLog *zap.SugaredLogger
+
+// Setup configures the HTTP server serving on addr
+//
+//	/healthz      the liveness checks
+//	/readyz       the readiness checks
+//	/debug/state  the cluster view and the routes managed as JSON
+//	/metrics      the prometheus metrics
+func Setup(Addr string, SLog *zap.SugaredLogger) {
+	addr = Addr
+	sLog = SLog
+}
+
+// AddLivenessCheck adds a check to /healthz, the process is restarted
+// while it fails
+func AddLivenessCheck(name string, fn func() error) {
+	liveness = append(liveness, check{name: name, check: fn})
+}
+
+// AddReadinessCheck adds a check to /readyz
+func AddReadinessCheck(name string, fn func() error) {
+	readiness = append(readiness, check{name: name, check: fn})
+}
+
+// Start serves in the background
+func Start() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		runChecks(w, liveness)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		runChecks(w, readiness)
+	})
+	mux.HandleFunc("/debug/state", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(state{
+			View:   view.State.Dump(),
+			Routes: dns_providers.DumpRoutes(),
+		})
+	})
+	mux.Handle("/metrics", metrics.Handler())
+	go func() {
+		sLog.Infof("Serving health, debug and metrics endpoints on %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			sLog.Panic(err)
+		}
+	}()
+}
+
+// runChecks answers 200 when every check passes, and 503 listing the
+// failed checks otherwise
+func runChecks(w http.ResponseWriter, checks []check) {
+	failed := make([]string, 0)
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", c.name, err))
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(failed) != 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		for _, msg := range failed {
+			fmt.Fprintln(w, msg)
+		}
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
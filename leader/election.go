@@ -0,0 +1,194 @@
+package leader
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/errors"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// recordAnnotation is the annotation of the ConfigMap holding the leader
+// record, it is the one used by the kubernetes leader election
+const recordAnnotation = "control-plane.alpha.kubernetes.io/leader"
+
+const (
+	// leaseDuration is how long standbys wait after the last renewal before
+	// taking over
+	leaseDuration = 15 * time.Second
+	// renewDeadline is how long the leader keeps trying to renew before
+	// stepping down
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// record is the leader record stored in the ConfigMap
+type record struct {
+	HolderIdentity       string    `json:"holderIdentity"`
+	LeaseDurationSeconds int       `json:"leaseDurationSeconds"`
+	AcquireTime          time.Time `json:"acquireTime"`
+	RenewTime            time.Time `json:"renewTime"`
+	LeaderTransitions    int       `json:"leaderTransitions"`
+}
+
+var client *kubernetes.Clientset
+var namespace, name, identity string
+var sLog *zap.SugaredLogger
+
+// observed is the last record seen and when it was seen, the lease of
+// another holder is measured with our own clock
+var observed record
+var observedTime time.Time
+
+// Setup configures the election of a leader among the replicas sharing
+// the ConfigMap Namespace/Name, Identity must be unique to each replica
+// (i.e. the pod name).
+func Setup(Client *kubernetes.Clientset, Namespace, Name, Identity string, SLog *zap.SugaredLogger) {
+	client = Client
+	namespace = Namespace
+	name = Name
+	identity = Identity
+	sLog = SLog
+}
+
+// Run takes part in the election until stop is closed, the returned
+// channel receives true when we become the leader and false when we lose
+// the leadership.
+func Run(stop <-chan struct{}) <-chan bool {
+	leading := make(chan bool, 1)
+	go func() {
+		for {
+			if !acquire(stop) {
+				return
+			}
+			sLog.Infof("%s became the leader", identity)
+			leading <- true
+			if !renew(stop) {
+				return
+			}
+			sLog.Warnf("%s lost the leadership", identity)
+			leading <- false
+		}
+	}()
+	return leading
+}
+
+// acquire waits until we hold the lease, it returns false when stopped
+func acquire(stop <-chan struct{}) bool {
+	ticker := time.NewTicker(retryPeriod)
+	defer ticker.Stop()
+	for {
+		err := tryAcquireOrRenew()
+		if err == nil {
+			return true
+		}
+		sLog.Debugf("Unable to acquire the leadership: %v", err)
+		select {
+		case <-stop:
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// renew keeps renewing the lease until it fails for renewDeadline, it
+// returns false when stopped
+func renew(stop <-chan struct{}) bool {
+	ticker := time.NewTicker(retryPeriod)
+	defer ticker.Stop()
+	lastRenew := time.Now()
+	for {
+		select {
+		case <-stop:
+			return false
+		case <-ticker.C:
+		}
+		if err := tryAcquireOrRenew(); err != nil {
+			sLog.Warnf("Unable to renew the leadership: %v", err)
+			if time.Since(lastRenew) > renewDeadline {
+				return true
+			}
+			continue
+		}
+		lastRenew = time.Now()
+	}
+}
+
+// tryAcquireOrRenew takes or renews the lease, it fails while another
+// replica holds it
+func tryAcquireOrRenew() error {
+	now := time.Now().UTC()
+	leader := record{
+		HolderIdentity:       identity,
+		LeaseDurationSeconds: int(leaseDuration / time.Second),
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+	cm, err := client.Core().ConfigMaps(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		cm = &v1.ConfigMap{}
+		cm.Namespace = namespace
+		cm.Name = name
+		if err := setRecord(cm, leader); err != nil {
+			return err
+		}
+		if _, err := client.Core().ConfigMaps(namespace).Create(cm); err != nil {
+			return err
+		}
+		observe(leader)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var current record
+	if value, ok := cm.Annotations[recordAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &current); err != nil {
+			return fmt.Errorf("Invalid leader record in %s/%s: %v", namespace, name, err)
+		}
+	}
+	if current.HolderIdentity != observed.HolderIdentity || !current.RenewTime.Equal(observed.RenewTime) {
+		observe(current)
+	}
+	if current.HolderIdentity != "" && current.HolderIdentity != identity &&
+		observedTime.Add(leaseDuration).After(now) {
+		return fmt.Errorf("the lease is held by %s", current.HolderIdentity)
+	}
+	if current.HolderIdentity == identity {
+		leader.AcquireTime = current.AcquireTime
+		leader.LeaderTransitions = current.LeaderTransitions
+	} else {
+		leader.LeaderTransitions = current.LeaderTransitions + 1
+	}
+	if err := setRecord(cm, leader); err != nil {
+		return err
+	}
+	// the resource version of cm makes the update fail when another replica
+	// updated the record in the meantime
+	if _, err := client.Core().ConfigMaps(namespace).Update(cm); err != nil {
+		return err
+	}
+	observe(leader)
+	return nil
+}
+
+func setRecord(cm *v1.ConfigMap, leader record) error {
+	value, err := json.Marshal(leader)
+	if err != nil {
+		return err
+	}
+	if cm.Annotations == nil {
+		cm.Annotations = make(map[string]string)
+	}
+	cm.Annotations[recordAnnotation] = string(value)
+	return nil
+}
+
+func observe(current record) {
+	observed = current
+	observedTime = time.Now()
+}
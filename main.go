@@ -2,9 +2,16 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/victor-fdez/kube-route53-traefik/dns_providers"
+	"github.com/victor-fdez/kube-route53-traefik/dns_server"
+	"github.com/victor-fdez/kube-route53-traefik/health"
 	"github.com/victor-fdez/kube-route53-traefik/watch"
 )
 
@@ -16,8 +23,39 @@ func main() {
 	var log *zap.Logger
 	var err error
 	kubeconfig := flag.String("kubeconfig", "", "absolute path to the kubeconfig file")
-	flag.BoolVar(&dryRun, "dry-run", false, "do not update route53 when setting this flag")
+	providerName := flag.String("provider", "aws", fmt.Sprintf("DNS provider to update, one of %v", dns_providers.Providers()))
+	memoryZones := flag.String("memory-zones", "", "comma separated list of zones served by the memory provider")
+	googleProject := flag.String("google-project", "", "project owning the Cloud DNS managed zones used by the google provider")
+	googleEndpoint := flag.String("google-endpoint", "", "Cloud DNS API endpoint used by the google provider (i.e. a local stand-in)")
+	azureSubscriptionID := flag.String("azure-subscription-id", "", "subscription owning the Azure DNS zones used by the azure provider")
+	azureResourceGroups := flag.String("azure-resource-groups", "", "comma separated list of resource groups searched for Azure DNS zones")
+	azureEndpoint := flag.String("azure-endpoint", "", "ARM endpoint used by the azure provider (i.e. a fake ARM endpoint)")
+	cloudflareEndpoint := flag.String("cloudflare-endpoint", "", "API endpoint used by the cloudflare provider (i.e. a mock API server)")
+	rfc2136Server := flag.String("rfc2136-server", "", "host:port of the DNS server receiving the rfc2136 updates")
+	rfc2136Zones := flag.String("rfc2136-zones", "", "comma separated list of zones managed by the rfc2136 provider")
+	rfc2136TSIGKey := flag.String("rfc2136-tsig-key", "", "name of the TSIG key signing the rfc2136 updates")
+	rfc2136TSIGAlgorithm := flag.String("rfc2136-tsig-algorithm", "hmac-sha256.", "algorithm of the TSIG key signing the rfc2136 updates")
+	etcdEndpoints := flag.String("etcd-endpoints", "", "comma separated list of etcd endpoints used by the etcd provider")
+	etcdPrefix := flag.String("etcd-prefix", "/skydns", "path prefix of the SkyDNS records written by the etcd provider")
+	etcdZones := flag.String("etcd-zones", "", "comma separated list of zones served by the CoreDNS etcd plugin")
+	webhookURL := flag.String("webhook-url", "", "base URL of the sidecar used by the webhook provider")
+	ownerID := flag.String("owner-id", "default", "identifies the records created by this instance, records owned by someone else are never changed")
+	policy := flag.String("policy", dns_providers.PolicySync, fmt.Sprintf("either %s to also delete the owned records nobody needs anymore, or %s to never delete them", dns_providers.PolicySync, dns_providers.PolicyUpsertOnly))
+	maxAttempts := flag.Int("max-attempts", 10, "how many times failed DNS changes are applied before giving up, throttled attempts are allowed three times as many tries")
+	retryFile := flag.String("retry-file", "", "file keeping the failed DNS changes waiting to be retried across restarts, empty keeps them in memory only")
+	resyncInterval := flag.Duration("resync-interval", 5*time.Minute, "how often the cluster objects are resynced and the DNS records reconciled with them, 0 only reconciles on startup")
+	leaderElect := flag.Bool("leader-elect", false, "elect a leader among the replicas, only the leader changes the DNS records")
+	leaderElectNamespace := flag.String("leader-elect-namespace", envOr("POD_NAMESPACE", "kube-system"), "namespace of the ConfigMap used for the leader election")
+	leaderElectName := flag.String("leader-elect-name", "kube-route53-traefik", "name of the ConfigMap used for the leader election")
+	leaderElectIdentity := flag.String("leader-elect-identity", envOr("POD_NAME", hostname()), "identity of this replica in the leader election")
+	listenAddress := flag.String("listen-address", ":8080", "address serving /healthz, /readyz, /debug/state and the prometheus /metrics, empty to disable")
+	serveDNS := flag.String("serve-dns", "", "address (i.e. :53) to serve the computed records from, combine with --provider=memory to not push them anywhere else")
+	serveDNSZones := flag.String("serve-dns-zones", "", "comma separated list of zones answered by --serve-dns")
+	serveDNSNameserver := flag.String("serve-dns-nameserver", "", "name of this server in the NS and SOA records, defaults to ns1.<zone>")
+	flag.BoolVar(&dryRun, "dry-run", false, "do not update the DNS provider when setting this flag")
 	flag.BoolVar(&isDev, "is-dev", false, "log output to console if in development mode")
+	output := flag.String("output", "table", "format of the plan and sync commands, table or json")
+	flag.Usage = usage
 	flag.Parse()
 	if isDev {
 		log, err = zap.NewDevelopment()
@@ -32,6 +70,104 @@ func main() {
 		log.Info("Running in DRYRUN mode")
 	}
 	sLog = log.Sugar()
-	watch.Setup(kubeconfig, dryRun, sLog)
+	// the memory provider keeps the records of the served zones when no
+	// other zones are given
+	if *serveDNS != "" && *memoryZones == "" {
+		*memoryZones = *serveDNSZones
+	}
+	err = dns_providers.Setup(dns_providers.Config{
+		Provider:             *providerName,
+		DryRun:               dryRun,
+		Policy:               *policy,
+		MaxAttempts:          *maxAttempts,
+		RetryFile:            *retryFile,
+		MemoryZones:          splitList(*memoryZones),
+		GoogleProject:        *googleProject,
+		GoogleEndpoint:       *googleEndpoint,
+		AzureSubscriptionID:  *azureSubscriptionID,
+		AzureResourceGroups:  splitList(*azureResourceGroups),
+		AzureEndpoint:        *azureEndpoint,
+		CloudflareEndpoint:   *cloudflareEndpoint,
+		RFC2136Server:        *rfc2136Server,
+		RFC2136Zones:         splitList(*rfc2136Zones),
+		RFC2136TSIGKey:       *rfc2136TSIGKey,
+		RFC2136TSIGAlgorithm: *rfc2136TSIGAlgorithm,
+		EtcdEndpoints:        splitList(*etcdEndpoints),
+		EtcdPrefix:           *etcdPrefix,
+		EtcdZones:            splitList(*etcdZones),
+		WebhookURL:           *webhookURL,
+	}, sLog)
+	if err != nil {
+		sLog.Panic(err)
+	}
+	watch.Setup(kubeconfig, *ownerID, *resyncInterval, sLog)
+	switch command := flag.Arg(0); command {
+	case "", "run":
+	case "plan":
+		os.Exit(runPlan(*output))
+	case "sync":
+		os.Exit(runSync(*output))
+	default:
+		sLog.Panicf("Unknown command %s, expected run, plan or sync", command)
+	}
+	if *leaderElect {
+		if *leaderElectIdentity == "" {
+			sLog.Panic("--leader-elect-identity is needed for the leader election")
+		}
+		watch.EnableLeaderElection(*leaderElectNamespace, *leaderElectName, *leaderElectIdentity)
+	}
+	if *listenAddress != "" {
+		health.Setup(*listenAddress, sLog)
+		health.AddLivenessCheck("watch", watch.Healthy)
+		health.AddReadinessCheck("watch", watch.Ready)
+		health.AddReadinessCheck("provider", dns_providers.Ping)
+		health.Start()
+	}
+	if *serveDNS != "" {
+		dns_server.Setup(*serveDNS, splitList(*serveDNSZones), *serveDNSNameserver, sLog)
+		dns_server.Start()
+	}
 	watch.Start()
 }
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: %s [flags] [command]
+
+Commands:
+  run   keep the DNS records in sync with the cluster (default)
+  plan  print the changes needed for the DNS records to match the cluster,
+        exits with 2 when there are changes
+  sync  reconcile the DNS records with the cluster once, print the changes
+        applied and exit (i.e. from a CronJob)
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+// envOr returns the environment variable name, or value when it is unset
+func envOr(name, value string) string {
+	if env := os.Getenv(name); env != "" {
+		return env
+	}
+	return value
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// splitList splits a comma separated flag value ignoring empty items
+func splitList(value string) []string {
+	items := make([]string, 0, 1)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
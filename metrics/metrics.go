@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "kube_route53_traefik"
+
+var (
+	// WatchEvents counts the events received from kubernetes by kind
+	// (ingress, ingressclass, ingressroute, ingressroutetcp, gateway,
+	// httproute, service, node) and type (ADDED, MODIFIED, DELETED)
+	WatchEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "watch_events_total",
+		Help:      "Events received from kubernetes by kind and type.",
+	}, []string{"kind", "type"})
+	// RecordChanges counts the record changes applied by zone and action
+	// (CREATE, UPSERT, DELETE), owner records are not counted
+	RecordChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "record_changes_total",
+		Help:      "Record changes applied to the DNS provider by zone and action.",
+	}, []string{"zone", "action"})
+	// ProviderRequests counts the calls to the DNS provider (i.e. route53)
+	// by operation (zones, records, apply)
+	ProviderRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_requests_total",
+		Help:      "Calls to the DNS provider by provider and operation.",
+	}, []string{"provider", "operation"})
+	// ProviderErrors counts the calls to the DNS provider which failed
+	ProviderErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_errors_total",
+		Help:      "Failed calls to the DNS provider by provider and operation.",
+	}, []string{"provider", "operation"})
+	// ProviderDuration observes how long the calls to the DNS provider take
+	ProviderDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "provider_request_duration_seconds",
+		Help:      "Duration of the calls to the DNS provider by provider and operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provider", "operation"})
+	// Routes is the number of routes currently managed
+	Routes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "routes",
+		Help:      "Routes currently managed in the DNS provider.",
+	})
+	// Ingresses, Nodes and IngressControllers are the number of objects in
+	// the cluster view
+	Ingresses = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ingresses",
+		Help:      "Ingresses in the cluster view.",
+	})
+	Nodes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "nodes",
+		Help:      "Nodes in the cluster view.",
+	})
+	IngressControllers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ingress_controllers",
+		Help:      "Ingress controller services in the cluster view.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		WatchEvents,
+		RecordChanges,
+		ProviderRequests,
+		ProviderErrors,
+		ProviderDuration,
+		Routes,
+		Ingresses,
+		Nodes,
+		IngressControllers,
+	)
+}
+
+// Handler serves the metrics in the prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
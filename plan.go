@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/victor-fdez/kube-route53-traefik/dns_providers"
+	"github.com/victor-fdez/kube-route53-traefik/watch"
+)
+
+// Exit codes of the plan and sync commands
+const (
+	exitNoDrift = 0
+	exitError   = 1
+	exitDrift   = 2
+)
+
+// listOnce lists the cluster into the view for the plan and sync
+// commands, tests replace it to skip the API server
+var listOnce = watch.ListOnce
+
+// runPlan lists the cluster once and prints the changes needed for the DNS
+// records to match it, in output format (table or json). It returns
+// exitDrift when there are changes so it can be used in CI.
+func runPlan(output string) int {
+	if output != "table" && output != "json" {
+		sLog.Errorf("Unknown output %s, expected table or json", output)
+		return exitError
+	}
+	if err := listOnce(); err != nil {
+		sLog.Error(err)
+		return exitError
+	}
+	changes, err := watch.Plan()
+	if err != nil {
+		sLog.Error(err)
+		return exitError
+	}
+	if err := printChanges(output, changes, "Plan: %d to create, %d to update, %d to delete."); err != nil {
+		sLog.Errorf("Unable to print the plan: %v", err)
+		return exitError
+	}
+	if len(changes) != 0 {
+		return exitDrift
+	}
+	return exitNoDrift
+}
+
+// runSync lists the cluster once, reconciles the DNS records with it and
+// prints the changes applied, for the environments running it as a CronJob
+// instead of a long-running controller
+func runSync(output string) int {
+	if output != "table" && output != "json" {
+		sLog.Errorf("Unknown output %s, expected table or json", output)
+		return exitError
+	}
+	if err := listOnce(); err != nil {
+		sLog.Error(err)
+		return exitError
+	}
+	// the changes of the zones reconciled are printed even when others failed
+	changes, err := watch.Sync()
+	status := exitNoDrift
+	if changes != nil {
+		if printErr := printChanges(output, changes, "Applied: %d created, %d updated, %d deleted."); printErr != nil {
+			sLog.Errorf("Unable to print the changes applied: %v", printErr)
+			status = exitError
+		}
+	}
+	if err != nil {
+		sLog.Error(err)
+		status = exitError
+	}
+	return status
+}
+
+// printChanges prints changes in output format, summary is the format of
+// the line counting the creations, updates and deletions of a table
+func printChanges(output string, changes []dns_providers.PlannedChange, summary string) error {
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(changes)
+	}
+	if len(changes) == 0 {
+		_, err := fmt.Println("No changes, the DNS records match the cluster.")
+		return err
+	}
+	counts := make(map[string]int)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ZONE\tACTION\tNAME\tTYPE\tVALUE\tPREVIOUS")
+	for _, change := range changes {
+		previous := "-"
+		if change.Previous != nil {
+			previous = recordValue(*change.Previous)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			change.Zone,
+			change.Action,
+			change.Record.Name,
+			change.Record.Type,
+			recordValue(change.Record),
+			previous)
+		counts[change.Action]++
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Printf("\n"+summary+"\n",
+		counts[dns_providers.PlanCreate],
+		counts[dns_providers.PlanUpdate],
+		counts[dns_providers.PlanDelete])
+	return err
+}
+
+func recordValue(record dns_providers.Record) string {
+	if record.Alias != "" {
+		return "alias " + record.Alias
+	}
+	return strings.Join(record.Targets, ",")
+}
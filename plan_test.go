@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+	"k8s.io/client-go/pkg/api/v1"
+	k8swatch "k8s.io/client-go/pkg/watch"
+
+	"github.com/victor-fdez/kube-route53-traefik/apis/networking"
+	"github.com/victor-fdez/kube-route53-traefik/dns_providers"
+	"github.com/victor-fdez/kube-route53-traefik/view"
+	"github.com/victor-fdez/kube-route53-traefik/watch"
+)
+
+// testKubeconfig points to an API server which is never called, the
+// cluster is listed by listClusterOnce instead
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: http://127.0.0.1:1
+contexts:
+- name: test
+  context:
+    cluster: test
+current-context: test
+`
+
+// failingChanges is a memory provider refusing every change
+type failingChanges struct {
+	*dns_providers.MemoryProvider
+}
+
+func (p failingChanges) ApplyChanges(zone dns_providers.Zone, changes []dns_providers.Change) error {
+	return fmt.Errorf("Changes refused")
+}
+
+// setupCommand sets the controller up the way main does for the plan and
+// sync commands, with p as the DNS provider and a cluster with a single
+// ingress
+func setupCommand(t *testing.T, p dns_providers.DNSProvider) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kubeconfig := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(kubeconfig, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sLog = zap.NewNop().Sugar()
+	watch.Setup(&kubeconfig, "test", 0, sLog)
+	dns_providers.SetProvider(p, false, sLog)
+	listOnce = listClusterOnce
+}
+
+func listClusterOnce() error {
+	node := &v1.Node{}
+	node.Status.NodeInfo.MachineID = "node-1"
+	node.Status.Addresses = []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "10.0.0.1"}}
+	view.State.UpdateNode(node, k8swatch.Added)
+	ingress := &networking.Ingress{ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "web"}}
+	ingress.Spec.Rules = []networking.IngressRule{{Host: "web.example.com"}}
+	view.State.UpdateIngress(ingress, k8swatch.Added)
+	return nil
+}
+
+// withStdout runs command with os.Stdout replaced by out
+func withStdout(out *os.File, command func() int) int {
+	stdout := os.Stdout
+	os.Stdout = out
+	defer func() { os.Stdout = stdout }()
+	return command()
+}
+
+func TestCommandExitCodes(t *testing.T) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	// writing to a closed file fails
+	closed, err := ioutil.TempFile("", "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(closed.Name())
+	closed.Close()
+
+	memory := dns_providers.NewMemoryProvider("example.com")
+	tests := []struct {
+		name     string
+		provider dns_providers.DNSProvider
+		list     func() error
+		out      *os.File
+		command  func() int
+		want     int
+	}{
+		{"plan with an unknown output", memory, nil, devNull, func() int { return runPlan("yaml") }, exitError},
+		{"plan failing to list the cluster", memory, func() error { return fmt.Errorf("Unable to list nodes") }, devNull, func() int { return runPlan("table") }, exitError},
+		{"plan failing to print", memory, nil, closed, func() int { return runPlan("json") }, exitError},
+		{"plan with changes", memory, nil, devNull, func() int { return runPlan("json") }, exitDrift},
+		{"sync with changes", dns_providers.NewMemoryProvider("example.com"), nil, devNull, func() int { return runSync("table") }, exitNoDrift},
+		{"sync failing to apply", failingChanges{memory}, nil, devNull, func() int { return runSync("table") }, exitError},
+		{"sync failing to print", memory, nil, closed, func() int { return runSync("json") }, exitError},
+		// the sync failing to print applied the changes all the same
+		{"plan without changes", memory, nil, devNull, func() int { return runPlan("table") }, exitNoDrift},
+		{"sync without changes", memory, nil, devNull, func() int { return runSync("table") }, exitNoDrift},
+		{"sync with an unknown output", memory, nil, devNull, func() int { return runSync("yaml") }, exitError},
+	}
+	for _, test := range tests {
+		setupCommand(t, test.provider)
+		if test.list != nil {
+			listOnce = test.list
+		}
+		if got := withStdout(test.out, test.command); got != test.want {
+			t.Errorf("%s: exit code %d, want %d", test.name, got, test.want)
+		}
+	}
+}
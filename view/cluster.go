@@ -2,13 +2,19 @@ package view
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	"go.uber.org/zap"
 
 	messagediff "gopkg.in/d4l3k/messagediff.v1"
 	"k8s.io/client-go/pkg/api/v1"
-	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
 	"k8s.io/client-go/pkg/watch"
+
+	"github.com/victor-fdez/kube-route53-traefik/apis/networking"
+	"github.com/victor-fdez/kube-route53-traefik/apis/traefik"
 )
 
 type Ingress struct {
@@ -16,6 +22,29 @@ type Ingress struct {
 	namespace   string
 	hostnames   []string
 	ingCtrlName string
+	// proxied is set through the route-cloudflare-proxied annotation, and
+	// asks cloudflare to proxy the traffic to the ingress hostnames
+	proxied bool
+	// kind is the kind of the kubernetes object (ingress, ingressroute or
+	// ingressroutetcp)
+	kind string
+	// entryPoints are the Traefik entry points of an IngressRoute, they
+	// choose its ingress controller when it has no class
+	entryPoints []string
+	// dns are the ExternalDNS annotations of the ingress
+	dns dnsOptions
+}
+
+// Kinds of Ingress
+const (
+	kindIngress         = "ingress"
+	kindIngressRoute    = "ingressroute"
+	kindIngressRouteTCP = "ingressroutetcp"
+)
+
+// resource names the ingress in the records it owns
+func (i Ingress) resource() string {
+	return i.kind + "/" + i.namespace + "/" + i.name
 }
 
 type Node struct {
@@ -27,14 +56,18 @@ type Node struct {
 // and the CN name (LBAlias) pointing to the load balancer
 // to the ingress controller.
 type IngressCtrl struct {
-	Name    string
-	SvcName string
-	LBAlias string
+	Name    string `json:"name"`
+	SvcName string `json:"svcName,omitempty"`
+	LBAlias string `json:"lbAlias"`
+	// EntryPoints are the Traefik entry points exposed by the service,
+	// listed in its route-traefik-entrypoints annotation
+	EntryPoints []string `json:"entryPoints,omitempty"`
 }
 
 func (i *IngressCtrl) init(svc *v1.Service) {
 	i.Name = svc.Name
 	i.Name = svc.Annotations["route-ing-ctrl"]
+	i.EntryPoints = splitAnnotation(svc.Annotations["route-traefik-entrypoints"])
 	ings := svc.Status.LoadBalancer.Ingress
 	if len(ings) == 1 {
 		i.LBAlias = ings[0].Hostname
@@ -44,6 +77,14 @@ func (i *IngressCtrl) init(svc *v1.Service) {
 	}
 }
 
+// IngressClass is an IngressClass of the cluster, the ingresses not
+// choosing a class use the default one
+type IngressClass struct {
+	Name       string `json:"name"`
+	Controller string `json:"controller"`
+	Default    bool   `json:"default,omitempty"`
+}
+
 // ClusterView contains current view of the kubernetes
 // cluster if any information pertaining to either
 // nodes in the cluster, ingresses, or ingress controllers
@@ -58,6 +99,14 @@ type ClusterView struct {
 	// traffic if they are specified to redirect traffic
 	// using kubernetes.io/ingress.class: ingCtrls(name)
 	ingCtrls map[string]IngressCtrl
+	// ingress classes by name, only served by networking.k8s.io/v1
+	ingClasses map[string]IngressClass
+	// Gateway API gateways and HTTPRoutes by namespace/name
+	gateways   map[string]Gateway
+	httpRoutes map[string]HTTPRoute
+	// load balancer services publishing their own hostnames by
+	// namespace/name
+	services map[string]Service
 }
 
 type RouteChanges struct {
@@ -66,10 +115,18 @@ type RouteChanges struct {
 }
 
 type Route struct {
-	Subdomain string
-	Ips       []string
-	Alias     string
-	UseAlias  bool
+	Subdomain string   `json:"subdomain"`
+	Ips       []string `json:"ips,omitempty"`
+	Alias     string   `json:"alias,omitempty"`
+	UseAlias  bool     `json:"useAlias"`
+	Proxied   bool     `json:"proxied,omitempty"`
+	// Resource is the kubernetes resource needing the route (i.e.
+	// ingress/default/web)
+	Resource string `json:"resource"`
+	// TTL of the records, the provider default when zero
+	TTL int64 `json:"ttl,omitempty"`
+	// CNAME publishes Alias as a CNAME record instead of an alias record
+	CNAME bool `json:"cname,omitempty"`
 }
 
 func NoRoutes() RouteChanges {
@@ -82,27 +139,68 @@ func NoRoutes() RouteChanges {
 var State ClusterView
 var sLog *zap.SugaredLogger
 
+// lock protects State, it is updated by the watch loop while other
+// goroutines (i.e. the DNS server) read the routes
+var lock sync.RWMutex
+
+// generation counts the updates of State, readers compare it to know when
+// to recompute what they derived from the routes
+var generation uint64
+
 func Setup(SLog *zap.SugaredLogger) {
+	lock.Lock()
+	defer lock.Unlock()
+	generation++
 	State = ClusterView{
-		ings:     make(map[string]Ingress),
-		nodes:    make(map[string]Node),
-		ingCtrls: make(map[string]IngressCtrl),
+		ings:       make(map[string]Ingress),
+		nodes:      make(map[string]Node),
+		ingCtrls:   make(map[string]IngressCtrl),
+		ingClasses: make(map[string]IngressClass),
+		gateways:   make(map[string]Gateway),
+		httpRoutes: make(map[string]HTTPRoute),
+		services:   make(map[string]Service),
 	}
 	sLog = SLog
 }
 
-func ingressKey(i *v1beta1.Ingress) string {
+func ingressKey(i *networking.Ingress) string {
 	return i.ObjectMeta.Namespace + "/" + i.ObjectMeta.Name
 }
 
 func (c ClusterView) ingressAlias(i Ingress) *string {
-	ingCtrl, ok := c.ingCtrls[i.ingCtrlName]
-	if i.ingCtrlName != "" && ok {
+	class := c.ingressClass(i)
+	ingCtrl, ok := c.ingCtrls[class]
+	if class != "" && ok {
 		return &ingCtrl.LBAlias
 	}
 	return nil
 }
 
+// ingressClass returns the class of the ingress, ingCtrlName is empty when
+// the ingress uses the default class, or for IngressRoutes the ingress
+// controller exposing their entry points
+func (c ClusterView) ingressClass(i Ingress) string {
+	if i.ingCtrlName != "" {
+		return i.ingCtrlName
+	}
+	if i.kind != kindIngress {
+		return c.entryPointsCtrl(i.entryPoints)
+	}
+	return c.defaultClass()
+}
+
+// defaultClass returns the name of the default ingress class, the first
+// by name if several are marked as default
+func (c ClusterView) defaultClass() string {
+	var name string
+	for _, class := range c.ingClasses {
+		if class.Default && (name == "" || class.Name < name) {
+			name = class.Name
+		}
+	}
+	return name
+}
+
 func key(s *v1.Service) (string, bool) {
 	if val, ok := s.Annotations["route-ing-ctrl"]; ok {
 		return val, true
@@ -110,7 +208,7 @@ func key(s *v1.Service) (string, bool) {
 	return "", false
 }
 
-func createIngress(i *v1beta1.Ingress) Ingress {
+func createIngress(i *networking.Ingress) Ingress {
 	// add all of the hosts for the ingress
 	hosts := make([]string, 0, len(i.Spec.Rules))
 	for _, rule := range i.Spec.Rules {
@@ -118,24 +216,143 @@ func createIngress(i *v1beta1.Ingress) Ingress {
 			hosts = append(hosts, rule.Host)
 		}
 	}
+	// older ingresses set their hosts in the Traefik v1 rule instead
+	if rule, ok := i.Annotations[traefik.FrontendRuleAnnotation]; ok {
+		ruleHosts, skipped := traefik.FrontendRuleHostnames(rule)
+		if len(skipped) != 0 {
+			sLog.Warnf("Ignoring hosts %v of the %s annotation on ingress %s/%s, regular expressions and wildcards cannot be published",
+				skipped, traefik.FrontendRuleAnnotation, i.Namespace, i.Name)
+		}
+		for _, host := range ruleHosts {
+			if !containsString(hosts, host) {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	dns := parseDNSOptions("ingress", i.ObjectMeta)
+	for _, host := range dns.hostnames {
+		if !containsString(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
 	//TODO: order host names
 	newIngress := Ingress{
-		name:      i.ObjectMeta.Name,
-		namespace: i.ObjectMeta.Namespace,
-		hostnames: hosts,
-	}
-	// get ingress controller name
-	if val, ok := i.Annotations["kubernetes.io/ingress.class"]; ok {
-		newIngress.ingCtrlName = val
+		name:        i.ObjectMeta.Name,
+		namespace:   i.ObjectMeta.Namespace,
+		hostnames:   hosts,
+		ingCtrlName: i.Class(),
+		kind:        kindIngress,
+		dns:         dns,
+	}
+	if val, ok := i.Annotations["route-cloudflare-proxied"]; ok {
+		proxied, err := strconv.ParseBool(val)
+		if err != nil {
+			sLog.Warnf("Ignoring invalid route-cloudflare-proxied annotation on ingress %s/%s: %v",
+				i.Namespace, i.Name, err)
+		}
+		newIngress.proxied = proxied
 	}
 	return newIngress
 }
 
-func (c ClusterView) Dump() {
-	//sLog.Debug(spew.Sdump(c))
+// Dump is a snapshot of the cluster view which can be encoded as JSON
+type Dump struct {
+	Ingresses          []IngressDump  `json:"ingresses"`
+	Nodes              []NodeDump     `json:"nodes"`
+	IngressControllers []IngressCtrl  `json:"ingressControllers"`
+	IngressClasses     []IngressClass `json:"ingressClasses"`
+	Gateways           []Gateway      `json:"gateways"`
+	HTTPRoutes         []HTTPRoute    `json:"httpRoutes"`
+	Services           []Service      `json:"services"`
+	Routes             []Route        `json:"routes"`
+}
+
+type IngressDump struct {
+	Kind         string   `json:"kind"`
+	Namespace    string   `json:"namespace"`
+	Name         string   `json:"name"`
+	Hostnames    []string `json:"hostnames"`
+	IngressClass string   `json:"ingressClass,omitempty"`
+	Proxied      bool     `json:"proxied,omitempty"`
+}
+
+type NodeDump struct {
+	MachineID  string `json:"machineId"`
+	ExternalIP string `json:"externalIp"`
 }
 
-func (c ClusterView) UpdateIngress(ingress *v1beta1.Ingress, eventType watch.EventType) RouteChanges {
+// Dump returns a snapshot of the cluster view and of the routes it needs,
+// sorted to be easy to compare
+func (c ClusterView) Dump() Dump {
+	routes := c.Routes()
+	lock.RLock()
+	defer lock.RUnlock()
+	dump := Dump{
+		Ingresses:          make([]IngressDump, 0, len(c.ings)),
+		Nodes:              make([]NodeDump, 0, len(c.nodes)),
+		IngressControllers: make([]IngressCtrl, 0, len(c.ingCtrls)),
+		IngressClasses:     make([]IngressClass, 0, len(c.ingClasses)),
+		Gateways:           make([]Gateway, 0, len(c.gateways)),
+		HTTPRoutes:         make([]HTTPRoute, 0, len(c.httpRoutes)),
+		Services:           make([]Service, 0, len(c.services)),
+		Routes:             routes,
+	}
+	for _, ingress := range c.ings {
+		dump.Ingresses = append(dump.Ingresses, IngressDump{
+			Kind:         ingress.kind,
+			Namespace:    ingress.namespace,
+			Name:         ingress.name,
+			Hostnames:    ingress.hostnames,
+			IngressClass: c.ingressClass(ingress),
+			Proxied:      ingress.proxied,
+		})
+	}
+	for _, node := range c.nodes {
+		dump.Nodes = append(dump.Nodes, NodeDump{MachineID: node.mID, ExternalIP: node.externalIP})
+	}
+	for _, ingCtrl := range c.ingCtrls {
+		dump.IngressControllers = append(dump.IngressControllers, ingCtrl)
+	}
+	for _, class := range c.ingClasses {
+		dump.IngressClasses = append(dump.IngressClasses, class)
+	}
+	for _, gw := range c.gateways {
+		dump.Gateways = append(dump.Gateways, gw)
+	}
+	for _, httpRoute := range c.httpRoutes {
+		dump.HTTPRoutes = append(dump.HTTPRoutes, httpRoute)
+	}
+	for _, service := range c.services {
+		dump.Services = append(dump.Services, service)
+	}
+	sort.Slice(dump.Ingresses, func(i, j int) bool {
+		a, b := dump.Ingresses[i], dump.Ingresses[j]
+		return a.Kind+"/"+a.Namespace+"/"+a.Name < b.Kind+"/"+b.Namespace+"/"+b.Name
+	})
+	sort.Slice(dump.Nodes, func(i, j int) bool { return dump.Nodes[i].MachineID < dump.Nodes[j].MachineID })
+	sort.Slice(dump.IngressControllers, func(i, j int) bool {
+		return dump.IngressControllers[i].Name < dump.IngressControllers[j].Name
+	})
+	sort.Slice(dump.IngressClasses, func(i, j int) bool {
+		return dump.IngressClasses[i].Name < dump.IngressClasses[j].Name
+	})
+	sort.Slice(dump.Gateways, func(i, j int) bool {
+		return dump.Gateways[i].Namespace+"/"+dump.Gateways[i].Name < dump.Gateways[j].Namespace+"/"+dump.Gateways[j].Name
+	})
+	sort.Slice(dump.HTTPRoutes, func(i, j int) bool {
+		return dump.HTTPRoutes[i].Namespace+"/"+dump.HTTPRoutes[i].Name < dump.HTTPRoutes[j].Namespace+"/"+dump.HTTPRoutes[j].Name
+	})
+	sort.Slice(dump.Services, func(i, j int) bool {
+		return dump.Services[i].Namespace+"/"+dump.Services[i].Name < dump.Services[j].Namespace+"/"+dump.Services[j].Name
+	})
+	sort.Slice(dump.Routes, func(i, j int) bool { return dump.Routes[i].Subdomain < dump.Routes[j].Subdomain })
+	return dump
+}
+
+func (c ClusterView) UpdateIngress(ingress *networking.Ingress, eventType watch.EventType) RouteChanges {
+	lock.Lock()
+	defer lock.Unlock()
+	generation++
 	var routeChanges RouteChanges
 	switch eventType {
 	case watch.Added:
@@ -150,7 +367,44 @@ func (c ClusterView) UpdateIngress(ingress *v1beta1.Ingress, eventType watch.Eve
 	return routeChanges
 }
 
+// UpdateIngressClass moves the ingresses not choosing a class to the new
+// default class when it changed
+func (c ClusterView) UpdateIngressClass(class *networking.IngressClass, eventType watch.EventType) RouteChanges {
+	lock.Lock()
+	defer lock.Unlock()
+	generation++
+	oldDefault := c.defaultClass()
+	switch eventType {
+	case watch.Added, watch.Modified:
+		c.ingClasses[class.Name] = IngressClass{
+			Name:       class.Name,
+			Controller: class.Spec.Controller,
+			Default:    class.IsDefault(),
+		}
+	case watch.Deleted:
+		delete(c.ingClasses, class.Name)
+	}
+	newDefault := c.defaultClass()
+	if newDefault == oldDefault {
+		return NoRoutes()
+	}
+	sLog.Infof("Default ingress class changed from [%v] to [%v]", oldDefault, newDefault)
+	ingresses := make([]Ingress, 0, 3)
+	for _, ingress := range c.ings {
+		if ingress.kind == kindIngress && ingress.ingCtrlName == "" {
+			ingresses = append(ingresses, ingress)
+		}
+	}
+	return RouteChanges{
+		Deleted: c.classRoutes(ingresses, oldDefault),
+		Changed: c.classRoutes(ingresses, newDefault),
+	}
+}
+
 func (c ClusterView) UpdateNode(node *v1.Node, eventType watch.EventType) RouteChanges {
+	lock.Lock()
+	defer lock.Unlock()
+	generation++
 	var routeChanges RouteChanges
 
 	switch eventType {
@@ -165,57 +419,85 @@ func (c ClusterView) UpdateNode(node *v1.Node, eventType watch.EventType) RouteC
 }
 
 func (c ClusterView) UpdateIngCtrlSvc(svc *v1.Service, eventType watch.EventType) RouteChanges {
+	lock.Lock()
+	defer lock.Unlock()
+	generation++
 	var routeChanges RouteChanges
 	switch eventType {
 	case watch.Added:
 		routeChanges = State.addCtrlSvc(svc)
+	case watch.Modified:
+		routeChanges = State.modCtrlSvc(svc)
 	case watch.Deleted:
 		routeChanges = State.delCtrlSvc(svc)
 	}
 	return routeChanges
 }
 
-func (c ClusterView) AddIngress(i *v1beta1.Ingress) RouteChanges {
-	key := ingressKey(i)
+func (c ClusterView) AddIngress(i *networking.Ingress) RouteChanges {
+	return c.addIngress(ingressKey(i), createIngress(i))
+}
+
+func (c ClusterView) DeleteIngress(i *networking.Ingress) RouteChanges {
+	return c.deleteIngress(ingressKey(i), createIngress(i))
+}
+
+func (c ClusterView) ModIngress(i *networking.Ingress) RouteChanges {
+	return c.modIngress(ingressKey(i), createIngress(i))
+}
+
+// updateIngress handles the events of every kind of ingress, key must be
+// unique among all the kinds
+func (c ClusterView) updateIngress(key string, ingress Ingress, eventType watch.EventType) RouteChanges {
+	switch eventType {
+	case watch.Added:
+		return c.addIngress(key, ingress)
+	case watch.Modified:
+		return c.modIngress(key, ingress)
+	case watch.Deleted:
+		return c.deleteIngress(key, ingress)
+	}
+	return NoRoutes()
+}
+
+func (c ClusterView) addIngress(key string, newIngress Ingress) RouteChanges {
 	_, ok := c.ings[key]
 	if ok {
-		sLog.Panic(fmt.Sprintf("Ingress already added - %#v\n", i))
+		// informers deliver the objects again after relisting
+		sLog.Infof("Ingress %s already added, handling it as modified", key)
+		return c.modIngress(key, newIngress)
 	}
-	newIngress := createIngress(i)
 	c.ings[key] = newIngress
 	alias := c.ingressAlias(newIngress)
 	return RouteChanges{
 		Deleted: []Route{},
-		Changed: c.createRoutes(newIngress.hostnames, alias),
+		Changed: c.createRoutes([]Ingress{newIngress}, alias),
 	}
 }
 
-func (c ClusterView) DeleteIngress(i *v1beta1.Ingress) RouteChanges {
-	key := ingressKey(i)
+func (c ClusterView) deleteIngress(key string, oldIngress Ingress) RouteChanges {
 	_, ok := c.ings[key]
 	if ok {
 		delete(c.ings, key)
 		sLog.Infof("Deleted Ingress with key = %v\n", key)
 	}
-	oldIngress := createIngress(i)
 	changes := RouteChanges{
 		Deleted: []Route{},
 		Changed: []Route{},
 	}
 	if len(c.nodes) != 0 {
 		alias := c.ingressAlias(oldIngress)
-		changes.Deleted = c.createRoutes(oldIngress.hostnames, alias)
+		changes.Deleted = c.createRoutes([]Ingress{oldIngress}, alias)
 	}
 	return changes
 }
 
-func (c ClusterView) ModIngress(i *v1beta1.Ingress) RouteChanges {
-	key := ingressKey(i)
+func (c ClusterView) modIngress(key string, newIngress Ingress) RouteChanges {
 	ingress, ok := c.ings[key]
 	if !ok {
-		sLog.Panic(fmt.Sprintf("Ingress does not exists but was modifed %#v", i))
+		sLog.Infof("Ingress %s does not exists but was modified, handling it as added", key)
+		return c.addIngress(key, newIngress)
 	}
-	newIngress := createIngress(i)
 	_, equal := messagediff.DeepDiff(ingress, newIngress)
 	if equal {
 		return RouteChanges{
@@ -226,8 +508,8 @@ func (c ClusterView) ModIngress(i *v1beta1.Ingress) RouteChanges {
 	c.ings[key] = newIngress
 	alias := c.ingressAlias(newIngress)
 	return RouteChanges{
-		Deleted: c.createRoutes(ingress.hostnames, alias),
-		Changed: c.createRoutes(newIngress.hostnames, alias),
+		Deleted: c.createRoutes([]Ingress{ingress}, alias),
+		Changed: c.createRoutes([]Ingress{newIngress}, alias),
 	}
 }
 
@@ -252,14 +534,15 @@ func (c ClusterView) AddNode(node *v1.Node) RouteChanges {
 	key := nodeKey(node)
 	_, ok := c.nodes[key]
 	if ok {
-		sLog.Panic(fmt.Sprintf("Node already added - %#v\n", node))
+		sLog.Infof("Node %s already added, handling it as modified", key)
+		return c.ModNode(node)
 	}
 	newNode := createNode(node)
 	c.nodes[key] = newNode
-	hostnames := c.getHostnames(false, "")
+	ingresses := c.getIngresses(false, "")
 	return RouteChanges{
 		Deleted: []Route{},
-		Changed: c.createRoutes(hostnames, nil),
+		Changed: c.createRoutes(ingresses, nil),
 	}
 }
 
@@ -270,10 +553,10 @@ func (c ClusterView) DeleteNode(node *v1.Node) RouteChanges {
 		delete(c.nodes, key)
 		sLog.Infof("Deleted node with key = %v\n", key)
 	}
-	hostnames := c.getHostnames(false, "")
+	ingresses := c.getIngresses(false, "")
 	return RouteChanges{
 		Deleted: []Route{},
-		Changed: c.createRoutes(hostnames, nil),
+		Changed: c.createRoutes(ingresses, nil),
 	}
 }
 
@@ -281,7 +564,8 @@ func (c ClusterView) ModNode(node *v1.Node) RouteChanges {
 	key := nodeKey(node)
 	oldNode, ok := c.nodes[key]
 	if !ok {
-		sLog.Panic(fmt.Sprintf("Node does not exists but was modifed %#v", node))
+		sLog.Infof("Node %s does not exists but was modified, handling it as added", key)
+		return c.AddNode(node)
 	}
 	newNode := createNode(node)
 	_, equal := messagediff.DeepDiff(oldNode, newNode)
@@ -292,10 +576,10 @@ func (c ClusterView) ModNode(node *v1.Node) RouteChanges {
 		}
 	}
 	c.nodes[key] = newNode
-	hostnames := c.getHostnames(false, "")
+	ingresses := c.getIngresses(false, "")
 	return RouteChanges{
 		Deleted: []Route{},
-		Changed: c.createRoutes(hostnames, nil),
+		Changed: c.createRoutes(ingresses, nil),
 	}
 }
 
@@ -309,17 +593,23 @@ func (c ClusterView) addCtrlSvc(svc *v1.Service) RouteChanges {
 	// we already have this service
 	_, ok = c.ingCtrls[key]
 	if ok {
-		sLog.Panic(fmt.Sprintf("Service already exists %#v", svc))
+		sLog.Infof("Service %s already exists, handling it as modified", svc.Name)
+		return c.modCtrlSvc(svc)
 	}
 	// add service and generate new routes if ingresses depend on this
 	// ingress controller
 	ingCtrl.init(svc)
 	c.ingCtrls[key] = ingCtrl
-	hostnames := c.getHostnames(true, ingCtrl.Name)
-	sLog.Infof("Got aliasable hostnames [%v]", hostnames)
+	if ingCtrl.LBAlias == "" {
+		// the load balancer is not ready yet, the routes are created
+		// once the service is modified
+		return NoRoutes()
+	}
+	ingresses := c.getIngresses(true, ingCtrl.Name)
+	sLog.Infof("Got aliasable hostnames [%v]", getHostnames(ingresses))
 	return RouteChanges{
 		Deleted: []Route{},
-		Changed: c.createRoutes(hostnames, &ingCtrl.LBAlias),
+		Changed: c.createRoutes(ingresses, &ingCtrl.LBAlias),
 	}
 }
 
@@ -333,19 +623,93 @@ func (c ClusterView) delCtrlSvc(svc *v1.Service) RouteChanges {
 	// if we don't have the service how can it be deleted
 	ing, ok = c.ingCtrls[key]
 	if !ok {
-		sLog.Panic(fmt.Sprintf("Service didn't exists but is being deleted %#v", svc))
+		sLog.Infof("Service %s didn't exists but is being deleted", svc.Name)
+		return NoRoutes()
 	}
 	delete(c.ingCtrls, key)
+	if ing.LBAlias == "" {
+		return NoRoutes()
+	}
 	// add service and generate new routes if ingresses depend on this
 	// ingress controller
-	hostnames := c.getHostnames(true, ing.Name)
-	sLog.Infof("Got aliasable hostnames [%v]", hostnames)
+	ingresses := c.getIngresses(true, ing.Name)
+	sLog.Infof("Got aliasable hostnames [%v]", getHostnames(ingresses))
 	return RouteChanges{
-		Deleted: c.createRoutes(hostnames, &ing.LBAlias),
+		Deleted: c.createRoutes(ingresses, &ing.LBAlias),
 		Changed: []Route{},
 	}
 }
 
+// modCtrlSvc updates the routes of the ingresses behind the ingress
+// controller when its load balancer changed
+func (c ClusterView) modCtrlSvc(svc *v1.Service) RouteChanges {
+	var ingCtrl IngressCtrl
+	key, ok := key(svc)
+	if !ok {
+		sLog.Infof("Ingress does not have annotation to be used by routing")
+		return NoRoutes()
+	}
+	oldIngCtrl, ok := c.ingCtrls[key]
+	if !ok {
+		sLog.Infof("Service %s does not exists but was modified, handling it as added", svc.Name)
+		return c.addCtrlSvc(svc)
+	}
+	ingCtrl.init(svc)
+	_, equal := messagediff.DeepDiff(oldIngCtrl, ingCtrl)
+	if equal {
+		return NoRoutes()
+	}
+	c.ingCtrls[key] = ingCtrl
+	changes := NoRoutes()
+	ingresses := c.getIngresses(true, ingCtrl.Name)
+	if ingCtrl.LBAlias == "" && oldIngCtrl.LBAlias != "" {
+		// the load balancer is gone
+		changes.Deleted = c.createRoutes(ingresses, &oldIngCtrl.LBAlias)
+	} else if ingCtrl.LBAlias != "" {
+		changes.Changed = c.createRoutes(ingresses, &ingCtrl.LBAlias)
+	}
+	return changes
+}
+
+// Counts returns the number of ingresses, nodes and ingress controllers
+// in the cluster view
+func (c ClusterView) Counts() (ingresses, nodes, ingCtrls int) {
+	lock.RLock()
+	defer lock.RUnlock()
+	return len(c.ings), len(c.nodes), len(c.ingCtrls)
+}
+
+// Generation returns a number changing on every update of the view
+func (c ClusterView) Generation() uint64 {
+	lock.RLock()
+	defer lock.RUnlock()
+	return generation
+}
+
+// Routes returns every route needed by the ingresses, HTTPRoutes and load
+// balancer services currently in the cluster
+func (c ClusterView) Routes() []Route {
+	lock.RLock()
+	defer lock.RUnlock()
+	routes := c.createRoutes(c.getIngresses(false, ""), nil)
+	for _, ingCtrl := range c.ingCtrls {
+		if ingCtrl.LBAlias == "" {
+			continue
+		}
+		alias := ingCtrl.LBAlias
+		routes = append(routes, c.createRoutes(c.getIngresses(true, ingCtrl.Name), &alias)...)
+	}
+	httpRoutes := make([]HTTPRoute, 0, len(c.httpRoutes))
+	for _, httpRoute := range c.httpRoutes {
+		httpRoutes = append(httpRoutes, httpRoute)
+	}
+	routes = append(routes, c.gatewayRoutes(httpRoutes)...)
+	for _, service := range c.services {
+		routes = append(routes, service.routes()...)
+	}
+	return routes
+}
+
 func (c ClusterView) getNodeIps() []string {
 	ips := make([]string, 0, 3)
 	for _, node := range c.nodes {
@@ -354,49 +718,92 @@ func (c ClusterView) getNodeIps() []string {
 	return ips
 }
 
-func (c ClusterView) getHostnames(onlyAliasable bool, ingCtrlName string) []string {
-	hostnames := make([]string, 0, 3)
+func (c ClusterView) getIngresses(onlyAliasable bool, ingCtrlName string) []Ingress {
+	ingresses := make([]Ingress, 0, 3)
 	for _, ingress := range c.ings {
-		if onlyAliasable && ingress.ingCtrlName == ingCtrlName {
-			ingressHostnames := ingress.hostnames
-			hostnames = append(hostnames, ingressHostnames...)
-		} else if !onlyAliasable && ingress.ingCtrlName == "" {
-			// only get this hostnames if aren't setup for ingress controllers
-			ingressHostnames := ingress.hostnames
-			hostnames = append(hostnames, ingressHostnames...)
+		class := c.ingressClass(ingress)
+		if onlyAliasable && class == ingCtrlName {
+			ingresses = append(ingresses, ingress)
+		} else if !onlyAliasable && class == "" {
+			// only get this ingresses if aren't setup for ingress controllers
+			ingresses = append(ingresses, ingress)
 		}
 	}
+	return ingresses
+}
+
+// classRoutes returns the routes of ingresses of class, through the load
+// balancer of its ingress controller or else the nodes when there is no
+// class
+func (c ClusterView) classRoutes(ingresses []Ingress, class string) []Route {
+	if class == "" {
+		return c.createRoutes(ingresses, nil)
+	}
+	ingCtrl, ok := c.ingCtrls[class]
+	if !ok || ingCtrl.LBAlias == "" {
+		return []Route{}
+	}
+	return c.createRoutes(ingresses, &ingCtrl.LBAlias)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validHostname tells if hostname can be published, an empty annotation
+// item or rule yields an empty hostname
+func validHostname(hostname string) bool {
+	return strings.Trim(strings.TrimSpace(hostname), ".") != ""
+}
+
+func getHostnames(ingresses []Ingress) []string {
+	hostnames := make([]string, 0, 3)
+	for _, ingress := range ingresses {
+		hostnames = append(hostnames, ingress.hostnames...)
+	}
 	return hostnames
 }
 
 // createRoutes will create AA routes with ips whenever ingCtrls is nil, else
-// it will create AA alias routes
-func (c ClusterView) createRoutes(hostnames []string, alias *string) []Route {
+// it will create AA alias routes. The ingresses with an ExternalDNS target
+// point to it instead.
+func (c ClusterView) createRoutes(ingresses []Ingress, alias *string) []Route {
 	var ips []string
 	ipRoutes := make([]Route, 0, 1)
 	// If we don't have an alias then use the IPs of the nodes
 	if alias == nil {
 		ips = c.getNodeIps()
 	}
-	if len(hostnames) != 0 &&
-		(alias != nil && len(ips) == 0) ||
-		(alias == nil && len(ips) != 0) {
-		for _, hostname := range hostnames {
-			if alias == nil {
-				ipRoutes = append(ipRoutes, Route{
-					Subdomain: hostname,
-					Ips:       ips,
-					UseAlias:  false,
-					Alias:     "",
-				})
-			} else {
-				ipRoutes = append(ipRoutes, Route{
-					Subdomain: hostname,
-					Ips:       []string{},
-					UseAlias:  true,
-					Alias:     *alias,
-				})
+	ready := (alias != nil && len(ips) == 0) ||
+		(alias == nil && len(ips) != 0)
+	for _, ingress := range ingresses {
+		if !ready && !ingress.dns.hasTarget() {
+			continue
+		}
+		for _, hostname := range ingress.hostnames {
+			if !validHostname(hostname) {
+				sLog.Warnf("Ignoring an empty hostname of %s", ingress.resource())
+				continue
+			}
+			route := Route{
+				Subdomain: hostname,
+				Ips:       ips,
+				UseAlias:  false,
+				Alias:     "",
+				Proxied:   ingress.proxied,
+				Resource:  ingress.resource(),
+			}
+			if alias != nil {
+				route.Ips = []string{}
+				route.UseAlias = true
+				route.Alias = *alias
 			}
+			ipRoutes = append(ipRoutes, ingress.dns.apply(route))
 		}
 	}
 	return ipRoutes
@@ -405,7 +812,7 @@ func (c ClusterView) createRoutes(hostnames []string, alias *string) []Route {
 func (c ClusterView) getIngCtrlHostnames(ingCtrlName string) []string {
 	hostnames := make([]string, 0, 1)
 	for _, ingress := range c.ings {
-		if ingress.ingCtrlName == ingCtrlName {
+		if c.ingressClass(ingress) == ingCtrlName {
 			ingressHostnames := ingress.hostnames
 			hostnames = append(hostnames, ingressHostnames...)
 		}
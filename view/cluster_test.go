@@ -0,0 +1,139 @@
+package view
+
+import (
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/watch"
+
+	"github.com/victor-fdez/kube-route53-traefik/apis/networking"
+	"github.com/victor-fdez/kube-route53-traefik/apis/traefik"
+)
+
+func testIngress(className, annotation string, hosts ...string) *networking.Ingress {
+	ing := &networking.Ingress{ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "web", Annotations: map[string]string{}}}
+	if className != "" {
+		ing.Spec.IngressClassName = &className
+	}
+	if annotation != "" {
+		ing.Annotations[networking.ClassAnnotation] = annotation
+	}
+	for _, host := range hosts {
+		ing.Spec.Rules = append(ing.Spec.Rules, networking.IngressRule{Host: host})
+	}
+	return ing
+}
+
+func testIngressClass(name string, isDefault bool) *networking.IngressClass {
+	class := &networking.IngressClass{ObjectMeta: v1.ObjectMeta{Name: name, Annotations: map[string]string{}}}
+	if isDefault {
+		class.Annotations[networking.DefaultClassAnnotation] = "true"
+	}
+	return class
+}
+
+// testIngCtrl is the service of the ingress controller name behind the load
+// balancer name.elb.amazonaws.com
+func testIngCtrl(name string) *v1.Service {
+	svc := &v1.Service{ObjectMeta: v1.ObjectMeta{
+		Namespace:   "kube-system",
+		Name:        name,
+		Annotations: map[string]string{"route-ing-ctrl": name},
+	}}
+	svc.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{Hostname: name + ".elb.amazonaws.com"}}
+	return svc
+}
+
+func testNode(ip string) *v1.Node {
+	node := &v1.Node{}
+	node.Status.NodeInfo.MachineID = "node-1"
+	node.Status.Addresses = []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: ip}}
+	return node
+}
+
+func aliasRoute(subdomain, alias string) Route {
+	return Route{Subdomain: subdomain, Ips: []string{}, UseAlias: true, Alias: alias, Resource: "ingress/default/web"}
+}
+
+func TestIngressClassPrecedence(t *testing.T) {
+	tests := []struct {
+		name         string
+		className    string
+		annotation   string
+		defaultClass string
+		want         []Route
+	}{
+		{
+			"ingressClassName before the annotation", "a", "b", "c",
+			[]Route{aliasRoute("web.example.com", "a.elb.amazonaws.com")},
+		},
+		{
+			"annotation before the default class", "", "b", "c",
+			[]Route{aliasRoute("web.example.com", "b.elb.amazonaws.com")},
+		},
+		{
+			"default class", "", "", "c",
+			[]Route{aliasRoute("web.example.com", "c.elb.amazonaws.com")},
+		},
+		{
+			"nodes without a class", "", "", "",
+			[]Route{{Subdomain: "web.example.com", Ips: []string{"10.0.0.1"}, Resource: "ingress/default/web"}},
+		},
+		{"class without an ingress controller", "missing", "", "c", []Route{}},
+	}
+	for _, test := range tests {
+		Setup(zap.NewNop().Sugar())
+		State.UpdateNode(testNode("10.0.0.1"), watch.Added)
+		for _, name := range []string{"a", "b", "c"} {
+			State.UpdateIngCtrlSvc(testIngCtrl(name), watch.Added)
+			State.UpdateIngressClass(testIngressClass(name, name == test.defaultClass), watch.Added)
+		}
+		State.UpdateIngress(testIngress(test.className, test.annotation, "web.example.com"), watch.Added)
+		routes := State.Routes()
+		if !reflect.DeepEqual(routes, test.want) {
+			t.Errorf("%s: Routes() = %+v, want %+v", test.name, routes, test.want)
+		}
+	}
+}
+
+func TestDefaultIngressClassChanged(t *testing.T) {
+	Setup(zap.NewNop().Sugar())
+	State.UpdateNode(testNode("10.0.0.1"), watch.Added)
+	State.UpdateIngCtrlSvc(testIngCtrl("c"), watch.Added)
+	State.UpdateIngress(testIngress("", "", "web.example.com"), watch.Added)
+	// the ingresses choosing a class keep it
+	api := testIngress("", "b", "api.example.com")
+	api.Name = "api"
+	State.UpdateIngress(api, watch.Added)
+
+	changes := State.UpdateIngressClass(testIngressClass("c", true), watch.Added)
+	nodeRoute := Route{Subdomain: "web.example.com", Ips: []string{"10.0.0.1"}, Resource: "ingress/default/web"}
+	want := RouteChanges{
+		Deleted: []Route{nodeRoute},
+		Changed: []Route{aliasRoute("web.example.com", "c.elb.amazonaws.com")},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("UpdateIngressClass() = %+v, want %+v", changes, want)
+	}
+
+	changes = State.UpdateIngressClass(testIngressClass("c", false), watch.Modified)
+	want = RouteChanges{
+		Deleted: []Route{aliasRoute("web.example.com", "c.elb.amazonaws.com")},
+		Changed: []Route{nodeRoute},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("UpdateIngressClass() = %+v, want %+v", changes, want)
+	}
+}
+
+func TestFrontendRuleHostsMerged(t *testing.T) {
+	Setup(zap.NewNop().Sugar())
+	ing := testIngress("", "", "a.example.com", "b.example.com")
+	ing.Annotations[traefik.FrontendRuleAnnotation] = "Host:b.example.com,c.example.com,*.example.com;PathPrefix:/x"
+	want := []string{"a.example.com", "b.example.com", "c.example.com"}
+	if hostnames := createIngress(ing).hostnames; !reflect.DeepEqual(hostnames, want) {
+		t.Errorf("createIngress() hostnames = %v, want %v", hostnames, want)
+	}
+}
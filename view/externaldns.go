@@ -0,0 +1,122 @@
+package view
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// Annotations of ExternalDNS, supported on ingresses and services so the
+// same manifests work with both controllers
+const (
+	externalDNSHostname = "external-dns.alpha.kubernetes.io/hostname"
+	externalDNSTTL      = "external-dns.alpha.kubernetes.io/ttl"
+	externalDNSTarget   = "external-dns.alpha.kubernetes.io/target"
+	externalDNSAlias    = "external-dns.alpha.kubernetes.io/alias"
+)
+
+// elbSuffix ends the hostnames of the ELB load balancers, the only targets
+// published as alias records
+const elbSuffix = ".elb.amazonaws.com"
+
+// dnsOptions are the ExternalDNS annotations of an ingress or a service
+type dnsOptions struct {
+	hostnames []string
+	// ttl of the records, the provider default when zero
+	ttl int64
+	// ips, or else hostname, replace what the routes point to
+	ips      []string
+	hostname string
+	// alias publishes hostname as an alias record instead of a CNAME, only
+	// set for the hostnames of ELB load balancers
+	alias bool
+}
+
+func parseDNSOptions(kind string, meta v1.ObjectMeta) dnsOptions {
+	var options dnsOptions
+	options.hostnames = splitAnnotation(meta.Annotations[externalDNSHostname])
+	if val, ok := meta.Annotations[externalDNSTTL]; ok {
+		ttl, err := parseTTL(val)
+		if err != nil {
+			sLog.Warnf("Ignoring invalid %s annotation on %s %s/%s: %v",
+				externalDNSTTL, kind, meta.Namespace, meta.Name, err)
+		}
+		options.ttl = ttl
+	}
+	for _, target := range splitAnnotation(meta.Annotations[externalDNSTarget]) {
+		if net.ParseIP(target) != nil {
+			options.ips = append(options.ips, target)
+		} else if options.hostname == "" {
+			options.hostname = target
+		}
+	}
+	if val, ok := meta.Annotations[externalDNSAlias]; ok {
+		alias, err := strconv.ParseBool(val)
+		if err != nil {
+			sLog.Warnf("Ignoring invalid %s annotation on %s %s/%s: %v",
+				externalDNSAlias, kind, meta.Namespace, meta.Name, err)
+		}
+		options.alias = alias
+	}
+	if options.alias && options.hostname != "" && !isELBHostname(options.hostname) {
+		sLog.Warnf("Publishing target %s of %s %s/%s as a CNAME, only the ELB load balancers (*%s) can be aliased",
+			options.hostname, kind, meta.Namespace, meta.Name, elbSuffix)
+		options.alias = false
+	}
+	return options
+}
+
+// hasTarget tells if the routes point to the target annotation
+func (o dnsOptions) hasTarget() bool {
+	return len(o.ips) != 0 || o.hostname != ""
+}
+
+// apply sets the TTL of route and points it to the target annotation, a
+// hostname target is published as a CNAME unless the alias annotation is
+// set like ExternalDNS does
+func (o dnsOptions) apply(route Route) Route {
+	route.TTL = o.ttl
+	if len(o.ips) != 0 {
+		route.Ips = o.ips
+		route.UseAlias = false
+		route.Alias = ""
+	} else if o.hostname != "" {
+		route.Ips = []string{}
+		route.UseAlias = true
+		route.Alias = o.hostname
+		route.CNAME = !o.alias
+	}
+	return route
+}
+
+func isELBHostname(hostname string) bool {
+	return strings.HasSuffix(strings.ToLower(strings.TrimSuffix(hostname, ".")), elbSuffix)
+}
+
+// parseTTL accepts seconds or a duration (i.e. 300 or 5m)
+func parseTTL(val string) (int64, error) {
+	if ttl, err := strconv.ParseInt(val, 10, 64); err == nil && ttl > 0 {
+		return ttl, nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, err
+	}
+	if d < time.Second {
+		return 0, strconv.ErrRange
+	}
+	return int64(d / time.Second), nil
+}
+
+func splitAnnotation(annotation string) []string {
+	var items []string
+	for _, item := range strings.Split(annotation, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,135 @@
+package view
+
+import (
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		val     string
+		ttl     int64
+		invalid bool
+	}{
+		{"300", 300, false},
+		{"5m", 300, false},
+		{"1h30m", 5400, false},
+		{"1.5s", 1, false},
+		{"0", 0, true},
+		{"-5", 0, true},
+		{"500ms", 0, true},
+		{"five", 0, true},
+		{"", 0, true},
+	}
+	for _, test := range tests {
+		ttl, err := parseTTL(test.val)
+		if ttl != test.ttl || (err != nil) != test.invalid {
+			t.Errorf("parseTTL(%q) = %d, %v, want %d and an error %v", test.val, ttl, err, test.ttl, test.invalid)
+		}
+	}
+}
+
+func TestParseDNSOptions(t *testing.T) {
+	Setup(zap.NewNop().Sugar())
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        dnsOptions
+	}{
+		{"no annotations", nil, dnsOptions{}},
+		{
+			"hostnames",
+			map[string]string{externalDNSHostname: "a.example.com, b.example.com,"},
+			dnsOptions{hostnames: []string{"a.example.com", "b.example.com"}},
+		},
+		{"ttl in seconds", map[string]string{externalDNSTTL: "60"}, dnsOptions{ttl: 60}},
+		{"ttl duration", map[string]string{externalDNSTTL: "2m"}, dnsOptions{ttl: 120}},
+		{"invalid ttl", map[string]string{externalDNSTTL: "soon"}, dnsOptions{}},
+		{
+			"ip targets",
+			map[string]string{externalDNSTarget: "10.0.0.1,10.0.0.2"},
+			dnsOptions{ips: []string{"10.0.0.1", "10.0.0.2"}},
+		},
+		{
+			"hostname target",
+			map[string]string{externalDNSTarget: "lb.example.net,lb2.example.net"},
+			dnsOptions{hostname: "lb.example.net"},
+		},
+		{
+			"ip and hostname targets",
+			map[string]string{externalDNSTarget: "lb.example.net,10.0.0.1"},
+			dnsOptions{ips: []string{"10.0.0.1"}, hostname: "lb.example.net"},
+		},
+		{
+			"alias of an elb",
+			map[string]string{externalDNSTarget: "web-1.us-east-1.elb.amazonaws.com", externalDNSAlias: "true"},
+			dnsOptions{hostname: "web-1.us-east-1.elb.amazonaws.com", alias: true},
+		},
+		{
+			"alias of an elb fqdn",
+			map[string]string{externalDNSTarget: "WEB-1.us-east-1.ELB.amazonaws.com.", externalDNSAlias: "true"},
+			dnsOptions{hostname: "WEB-1.us-east-1.ELB.amazonaws.com.", alias: true},
+		},
+		{
+			"alias of another hostname is a cname",
+			map[string]string{externalDNSTarget: "lb.example.net", externalDNSAlias: "true"},
+			dnsOptions{hostname: "lb.example.net"},
+		},
+		{
+			"alias off",
+			map[string]string{externalDNSTarget: "web-1.us-east-1.elb.amazonaws.com", externalDNSAlias: "false"},
+			dnsOptions{hostname: "web-1.us-east-1.elb.amazonaws.com"},
+		},
+		{
+			"invalid alias",
+			map[string]string{externalDNSTarget: "web-1.us-east-1.elb.amazonaws.com", externalDNSAlias: "yes"},
+			dnsOptions{hostname: "web-1.us-east-1.elb.amazonaws.com"},
+		},
+	}
+	for _, test := range tests {
+		meta := v1.ObjectMeta{Namespace: "default", Name: "web", Annotations: test.annotations}
+		options := parseDNSOptions("ingress", meta)
+		if !reflect.DeepEqual(options, test.want) {
+			t.Errorf("%s: parseDNSOptions() = %+v, want %+v", test.name, options, test.want)
+		}
+	}
+}
+
+func TestDNSOptionsApply(t *testing.T) {
+	route := Route{Subdomain: "web.example.com", Ips: []string{"10.0.0.9"}, Resource: "ingress/default/web"}
+	tests := []struct {
+		name    string
+		options dnsOptions
+		want    Route
+	}{
+		{"no options", dnsOptions{}, route},
+		{
+			"ttl", dnsOptions{ttl: 60},
+			Route{Subdomain: "web.example.com", Ips: []string{"10.0.0.9"}, Resource: "ingress/default/web", TTL: 60},
+		},
+		{
+			"ip target", dnsOptions{ips: []string{"10.0.0.1"}},
+			Route{Subdomain: "web.example.com", Ips: []string{"10.0.0.1"}, Resource: "ingress/default/web"},
+		},
+		{
+			"hostname target", dnsOptions{hostname: "lb.example.net"},
+			Route{Subdomain: "web.example.com", Ips: []string{}, UseAlias: true, Alias: "lb.example.net", CNAME: true, Resource: "ingress/default/web"},
+		},
+		{
+			"alias target", dnsOptions{hostname: "web-1.us-east-1.elb.amazonaws.com", alias: true},
+			Route{Subdomain: "web.example.com", Ips: []string{}, UseAlias: true, Alias: "web-1.us-east-1.elb.amazonaws.com", Resource: "ingress/default/web"},
+		},
+		{
+			"ip before hostname target", dnsOptions{ips: []string{"10.0.0.1"}, hostname: "lb.example.net"},
+			Route{Subdomain: "web.example.com", Ips: []string{"10.0.0.1"}, Resource: "ingress/default/web"},
+		},
+	}
+	for _, test := range tests {
+		if applied := test.options.apply(route); !reflect.DeepEqual(applied, test.want) {
+			t.Errorf("%s: apply() = %+v, want %+v", test.name, applied, test.want)
+		}
+	}
+}
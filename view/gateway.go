@@ -0,0 +1,166 @@
+package view
+
+import (
+	"sort"
+
+	messagediff "gopkg.in/d4l3k/messagediff.v1"
+	"k8s.io/client-go/pkg/watch"
+
+	"github.com/victor-fdez/kube-route53-traefik/apis/gateway"
+)
+
+// Gateway is a Gateway API gateway, the hostnames of the HTTPRoutes
+// attached to it point to its IPs, or else to its Hostname through an
+// alias
+type Gateway struct {
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	IPs       []string `json:"ips,omitempty"`
+	Hostname  string   `json:"hostname,omitempty"`
+}
+
+// HTTPRoute is a Gateway API HTTPRoute, Gateways are the namespace/name of
+// the gateways it is attached to
+type HTTPRoute struct {
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Hostnames []string `json:"hostnames"`
+	Gateways  []string `json:"gateways"`
+}
+
+// UpdateGateway updates the routes of the HTTPRoutes attached to the
+// gateway when its addresses changed
+func (c ClusterView) UpdateGateway(gw *gateway.Gateway, eventType watch.EventType) RouteChanges {
+	lock.Lock()
+	defer lock.Unlock()
+	generation++
+	key := gw.Namespace + "/" + gw.Name
+	httpRoutes := make([]HTTPRoute, 0, 1)
+	for _, httpRoute := range c.httpRoutes {
+		for _, parent := range httpRoute.Gateways {
+			if parent == key {
+				httpRoutes = append(httpRoutes, httpRoute)
+				break
+			}
+		}
+	}
+	oldRoutes := c.gatewayRoutes(httpRoutes)
+	switch eventType {
+	case watch.Added, watch.Modified:
+		c.gateways[key] = Gateway{
+			Namespace: gw.Namespace,
+			Name:      gw.Name,
+			IPs:       gw.IPs(),
+			Hostname:  gw.Hostname(),
+		}
+	case watch.Deleted:
+		delete(c.gateways, key)
+	}
+	newRoutes := c.gatewayRoutes(httpRoutes)
+	if _, equal := messagediff.DeepDiff(oldRoutes, newRoutes); equal {
+		return NoRoutes()
+	}
+	return RouteChanges{
+		Deleted: oldRoutes,
+		Changed: newRoutes,
+	}
+}
+
+// UpdateHTTPRoute routes the hostnames of an HTTPRoute to the gateways it
+// is attached to
+func (c ClusterView) UpdateHTTPRoute(route *gateway.HTTPRoute, eventType watch.EventType) RouteChanges {
+	lock.Lock()
+	defer lock.Unlock()
+	generation++
+	key := route.Namespace + "/" + route.Name
+	oldRoutes := []Route{}
+	if httpRoute, ok := c.httpRoutes[key]; ok {
+		oldRoutes = c.gatewayRoutes([]HTTPRoute{httpRoute})
+	}
+	switch eventType {
+	case watch.Added, watch.Modified:
+		c.httpRoutes[key] = HTTPRoute{
+			Namespace: route.Namespace,
+			Name:      route.Name,
+			Hostnames: route.Spec.Hostnames,
+			Gateways:  route.Gateways(),
+		}
+	case watch.Deleted:
+		delete(c.httpRoutes, key)
+	}
+	newRoutes := []Route{}
+	if httpRoute, ok := c.httpRoutes[key]; ok {
+		newRoutes = c.gatewayRoutes([]HTTPRoute{httpRoute})
+	}
+	if _, equal := messagediff.DeepDiff(oldRoutes, newRoutes); equal {
+		return NoRoutes()
+	}
+	return RouteChanges{
+		Deleted: oldRoutes,
+		Changed: newRoutes,
+	}
+}
+
+// gatewayRoutes creates the routes of the hostnames of httpRoutes through
+// the gateways they are attached to, the gateways without an address are
+// skipped. Each hostname of an HTTPRoute is routed once, to the IPs of all
+// its gateways, or else to the hostname of its first gateway by name
+func (c ClusterView) gatewayRoutes(httpRoutes []HTTPRoute) []Route {
+	routes := make([]Route, 0, 1)
+	for _, httpRoute := range httpRoutes {
+		keys := append([]string{}, httpRoute.Gateways...)
+		sort.Strings(keys)
+		ips := make([]string, 0, 1)
+		hostname := ""
+		for _, key := range keys {
+			gw, ok := c.gateways[key]
+			if !ok {
+				continue
+			}
+			for _, ip := range gw.IPs {
+				if !containsString(ips, ip) {
+					ips = append(ips, ip)
+				}
+			}
+			if hostname == "" {
+				hostname = gw.Hostname
+			}
+		}
+		if len(ips) == 0 && hostname == "" {
+			continue
+		}
+		hostnames := make([]string, 0, len(httpRoute.Hostnames))
+		for _, subdomain := range httpRoute.Hostnames {
+			if !containsString(hostnames, subdomain) {
+				hostnames = append(hostnames, subdomain)
+			}
+		}
+		resource := "httproute/" + httpRoute.Namespace + "/" + httpRoute.Name
+		routes = append(routes, addressRoutes(hostnames, ips, hostname, resource)...)
+	}
+	return routes
+}
+
+// addressRoutes creates the routes of hostnames pointing to ips, or else
+// to an alias of hostname (i.e. a load balancer)
+func addressRoutes(hostnames, ips []string, hostname, resource string) []Route {
+	routes := make([]Route, 0, len(hostnames))
+	for _, subdomain := range hostnames {
+		if !validHostname(subdomain) {
+			sLog.Warnf("Ignoring an empty hostname of %s", resource)
+			continue
+		}
+		route := Route{
+			Subdomain: subdomain,
+			Ips:       ips,
+			Resource:  resource,
+		}
+		if len(ips) == 0 {
+			route.Ips = []string{}
+			route.UseAlias = true
+			route.Alias = hostname
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
@@ -0,0 +1,155 @@
+package view
+
+import (
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/watch"
+
+	"github.com/victor-fdez/kube-route53-traefik/apis/gateway"
+)
+
+func testGateway(name string, addresses ...gateway.GatewayAddress) *gateway.Gateway {
+	gw := &gateway.Gateway{ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: name}}
+	gw.Status.Addresses = addresses
+	return gw
+}
+
+func address(addressType, value string) gateway.GatewayAddress {
+	return gateway.GatewayAddress{Type: &addressType, Value: value}
+}
+
+func testHTTPRoute(gateways []string, hostnames ...string) *gateway.HTTPRoute {
+	route := &gateway.HTTPRoute{ObjectMeta: v1.ObjectMeta{Namespace: "default", Name: "web"}}
+	for _, name := range gateways {
+		route.Spec.ParentRefs = append(route.Spec.ParentRefs, gateway.ParentReference{Name: name})
+	}
+	route.Spec.Hostnames = hostnames
+	return route
+}
+
+func TestAddressRoutes(t *testing.T) {
+	Setup(zap.NewNop().Sugar())
+	tests := []struct {
+		name      string
+		hostnames []string
+		ips       []string
+		hostname  string
+		want      []Route
+	}{
+		{
+			"ips", []string{"web.example.com"}, []string{"10.0.0.1"}, "lb.example.net",
+			[]Route{{Subdomain: "web.example.com", Ips: []string{"10.0.0.1"}, Resource: "test"}},
+		},
+		{
+			"alias without ips", []string{"web.example.com"}, nil, "lb.example.net",
+			[]Route{{Subdomain: "web.example.com", Ips: []string{}, UseAlias: true, Alias: "lb.example.net", Resource: "test"}},
+		},
+		{
+			"invalid hostnames", []string{"", "web.example.com"}, []string{"10.0.0.1"}, "",
+			[]Route{{Subdomain: "web.example.com", Ips: []string{"10.0.0.1"}, Resource: "test"}},
+		},
+		{"no hostnames", nil, []string{"10.0.0.1"}, "", []Route{}},
+	}
+	for _, test := range tests {
+		routes := addressRoutes(test.hostnames, test.ips, test.hostname, "test")
+		if !reflect.DeepEqual(routes, test.want) {
+			t.Errorf("%s: addressRoutes() = %+v, want %+v", test.name, routes, test.want)
+		}
+	}
+}
+
+func TestGatewayRoutes(t *testing.T) {
+	tests := []struct {
+		name      string
+		gateways  []Gateway
+		httpRoute HTTPRoute
+		want      []Route
+	}{
+		{
+			"one gateway",
+			[]Gateway{{Namespace: "default", Name: "a", IPs: []string{"10.0.0.1"}}},
+			HTTPRoute{Namespace: "default", Name: "web", Hostnames: []string{"web.example.com"}, Gateways: []string{"default/a"}},
+			[]Route{{Subdomain: "web.example.com", Ips: []string{"10.0.0.1"}, Resource: "httproute/default/web"}},
+		},
+		{
+			"two gateways merge their ips",
+			[]Gateway{
+				{Namespace: "default", Name: "a", IPs: []string{"10.0.0.1"}},
+				{Namespace: "default", Name: "b", IPs: []string{"10.0.0.2", "10.0.0.1"}},
+			},
+			HTTPRoute{Namespace: "default", Name: "web", Hostnames: []string{"web.example.com"}, Gateways: []string{"default/b", "default/a"}},
+			[]Route{{Subdomain: "web.example.com", Ips: []string{"10.0.0.1", "10.0.0.2"}, Resource: "httproute/default/web"}},
+		},
+		{
+			"gateway and hostname listed twice",
+			[]Gateway{{Namespace: "default", Name: "a", IPs: []string{"10.0.0.1"}}},
+			HTTPRoute{Namespace: "default", Name: "web", Hostnames: []string{"web.example.com", "web.example.com"}, Gateways: []string{"default/a", "default/a"}},
+			[]Route{{Subdomain: "web.example.com", Ips: []string{"10.0.0.1"}, Resource: "httproute/default/web"}},
+		},
+		{
+			"alias of the first gateway by name",
+			[]Gateway{
+				{Namespace: "default", Name: "b", Hostname: "b.example.net"},
+				{Namespace: "default", Name: "a", Hostname: "a.example.net"},
+			},
+			HTTPRoute{Namespace: "default", Name: "web", Hostnames: []string{"web.example.com"}, Gateways: []string{"default/b", "default/a"}},
+			[]Route{{Subdomain: "web.example.com", Ips: []string{}, UseAlias: true, Alias: "a.example.net", Resource: "httproute/default/web"}},
+		},
+		{
+			"ips before an alias",
+			[]Gateway{
+				{Namespace: "default", Name: "a", Hostname: "a.example.net"},
+				{Namespace: "default", Name: "b", IPs: []string{"10.0.0.2"}},
+			},
+			HTTPRoute{Namespace: "default", Name: "web", Hostnames: []string{"web.example.com"}, Gateways: []string{"default/a", "default/b"}},
+			[]Route{{Subdomain: "web.example.com", Ips: []string{"10.0.0.2"}, Resource: "httproute/default/web"}},
+		},
+		{
+			"gateways without an address",
+			[]Gateway{{Namespace: "default", Name: "a"}},
+			HTTPRoute{Namespace: "default", Name: "web", Hostnames: []string{"web.example.com"}, Gateways: []string{"default/a", "default/missing"}},
+			[]Route{},
+		},
+	}
+	for _, test := range tests {
+		Setup(zap.NewNop().Sugar())
+		for _, gw := range test.gateways {
+			State.gateways[gw.Namespace+"/"+gw.Name] = gw
+		}
+		routes := State.gatewayRoutes([]HTTPRoute{test.httpRoute})
+		if !reflect.DeepEqual(routes, test.want) {
+			t.Errorf("%s: gatewayRoutes() = %+v, want %+v", test.name, routes, test.want)
+		}
+	}
+}
+
+func TestUpdateGatewayPublishesIPv4(t *testing.T) {
+	Setup(zap.NewNop().Sugar())
+	State.UpdateHTTPRoute(testHTTPRoute([]string{"a"}, "web.example.com"), watch.Added)
+	gw := testGateway("a",
+		address(gateway.AddressIPAddress, "10.0.0.1"),
+		address(gateway.AddressIPAddress, "2001:db8::1"),
+		gateway.GatewayAddress{Value: "10.0.0.2"},
+		gateway.GatewayAddress{Value: "2001:db8::2"},
+		address(gateway.AddressHostname, "lb.example.net"),
+	)
+	changes := State.UpdateGateway(gw, watch.Added)
+	want := []Route{{Subdomain: "web.example.com", Ips: []string{"10.0.0.1", "10.0.0.2"}, Resource: "httproute/default/web"}}
+	if !reflect.DeepEqual(changes.Changed, want) {
+		t.Errorf("UpdateGateway() changed %+v, want %+v", changes.Changed, want)
+	}
+
+	// a gateway with IPv6 addresses only is routed through its hostname
+	gw = testGateway("a",
+		address(gateway.AddressIPAddress, "2001:db8::1"),
+		address(gateway.AddressHostname, "lb.example.net"),
+	)
+	changes = State.UpdateGateway(gw, watch.Modified)
+	want = []Route{{Subdomain: "web.example.com", Ips: []string{}, UseAlias: true, Alias: "lb.example.net", Resource: "httproute/default/web"}}
+	if !reflect.DeepEqual(changes.Changed, want) {
+		t.Errorf("UpdateGateway() changed %+v, want %+v", changes.Changed, want)
+	}
+}
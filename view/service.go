@@ -0,0 +1,110 @@
+package view
+
+import (
+	"net"
+
+	messagediff "gopkg.in/d4l3k/messagediff.v1"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/watch"
+)
+
+// hostnameAnnotation lists the hostnames (i.e. db.example.com,db2.example.com)
+// pointing to the load balancer of a Service of type LoadBalancer, like the
+// ExternalDNS hostname annotation
+const hostnameAnnotation = "route-hostname"
+
+// Service is a Service of type LoadBalancer publishing its own hostnames,
+// they point to the IPs of its load balancer, or else to its Hostname
+// through an alias
+type Service struct {
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Hostnames []string `json:"hostnames"`
+	IPs       []string `json:"ips,omitempty"`
+	Hostname  string   `json:"hostname,omitempty"`
+	// dns are the ExternalDNS annotations of the service
+	dns dnsOptions
+}
+
+// UpdateService routes the hostnames of the route-hostname or ExternalDNS
+// annotations of a service to its load balancer, the services without them
+// are ignored
+func (c ClusterView) UpdateService(svc *v1.Service, eventType watch.EventType) RouteChanges {
+	lock.Lock()
+	defer lock.Unlock()
+	generation++
+	key := svc.Namespace + "/" + svc.Name
+	oldRoutes := []Route{}
+	if service, ok := c.services[key]; ok {
+		oldRoutes = service.routes()
+	}
+	delete(c.services, key)
+	if eventType == watch.Added || eventType == watch.Modified {
+		if service, ok := createService(svc); ok {
+			c.services[key] = service
+		}
+	}
+	newRoutes := []Route{}
+	if service, ok := c.services[key]; ok {
+		newRoutes = service.routes()
+	}
+	if _, equal := messagediff.DeepDiff(oldRoutes, newRoutes); equal {
+		return NoRoutes()
+	}
+	return RouteChanges{
+		Deleted: oldRoutes,
+		Changed: newRoutes,
+	}
+}
+
+// createService returns false when the service does not publish hostnames
+func createService(svc *v1.Service) (Service, bool) {
+	dns := parseDNSOptions("service", svc.ObjectMeta)
+	hostnames := splitAnnotation(svc.Annotations[hostnameAnnotation])
+	for _, hostname := range dns.hostnames {
+		if !containsString(hostnames, hostname) {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	if len(hostnames) == 0 {
+		return Service{}, false
+	}
+	if svc.Spec.Type != v1.ServiceTypeLoadBalancer && !dns.hasTarget() {
+		sLog.Warnf("Ignoring the hostnames of service %s/%s, it is not of type LoadBalancer and has no %s annotation",
+			svc.Namespace, svc.Name, externalDNSTarget)
+		return Service{}, false
+	}
+	service := Service{
+		Namespace: svc.Namespace,
+		Name:      svc.Name,
+		Hostnames: hostnames,
+		IPs:       make([]string, 0, 1),
+		dns:       dns,
+	}
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		// the IPv6 addresses cannot be published in A records
+		ip := net.ParseIP(ingress.IP)
+		if ingress.IP != "" && (ip == nil || ip.To4() == nil) {
+			sLog.Warnf("Ignoring address %s of service %s/%s, only IPv4 addresses are published", ingress.IP, svc.Namespace, svc.Name)
+			ip = nil
+		}
+		if ip != nil {
+			service.IPs = append(service.IPs, ingress.IP)
+		} else if ingress.Hostname != "" && service.Hostname == "" {
+			service.Hostname = ingress.Hostname
+		}
+	}
+	return service, true
+}
+
+// routes returns no routes until the load balancer is ready
+func (s Service) routes() []Route {
+	if len(s.IPs) == 0 && s.Hostname == "" && !s.dns.hasTarget() {
+		return []Route{}
+	}
+	routes := addressRoutes(s.Hostnames, s.IPs, s.Hostname, "service/"+s.Namespace+"/"+s.Name)
+	for i := range routes {
+		routes[i] = s.dns.apply(routes[i])
+	}
+	return routes
+}
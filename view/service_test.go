@@ -0,0 +1,109 @@
+package view
+
+import (
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/watch"
+)
+
+func testService(serviceType v1.ServiceType, hostnames string, ingresses ...v1.LoadBalancerIngress) *v1.Service {
+	svc := &v1.Service{ObjectMeta: v1.ObjectMeta{
+		Namespace:   "default",
+		Name:        "db",
+		Annotations: map[string]string{hostnameAnnotation: hostnames},
+	}}
+	svc.Spec.Type = serviceType
+	svc.Status.LoadBalancer.Ingress = ingresses
+	return svc
+}
+
+func TestServiceRoutes(t *testing.T) {
+	tests := []struct {
+		name string
+		svc  *v1.Service
+		want []Route
+	}{
+		{
+			"load balancer ips",
+			testService(v1.ServiceTypeLoadBalancer, "db.example.com,db2.example.com",
+				v1.LoadBalancerIngress{IP: "10.0.0.1"}, v1.LoadBalancerIngress{IP: "10.0.0.2"}),
+			[]Route{
+				{Subdomain: "db.example.com", Ips: []string{"10.0.0.1", "10.0.0.2"}, Resource: "service/default/db"},
+				{Subdomain: "db2.example.com", Ips: []string{"10.0.0.1", "10.0.0.2"}, Resource: "service/default/db"},
+			},
+		},
+		{
+			"load balancer hostname",
+			testService(v1.ServiceTypeLoadBalancer, "db.example.com",
+				v1.LoadBalancerIngress{Hostname: "db.elb.amazonaws.com"}),
+			[]Route{{Subdomain: "db.example.com", Ips: []string{}, UseAlias: true, Alias: "db.elb.amazonaws.com", Resource: "service/default/db"}},
+		},
+		{
+			"ipv6 addresses are not published",
+			testService(v1.ServiceTypeLoadBalancer, "db.example.com",
+				v1.LoadBalancerIngress{IP: "2001:db8::1"}, v1.LoadBalancerIngress{IP: "10.0.0.1"}),
+			[]Route{{Subdomain: "db.example.com", Ips: []string{"10.0.0.1"}, Resource: "service/default/db"}},
+		},
+		{
+			"hostname of an ipv6 load balancer",
+			testService(v1.ServiceTypeLoadBalancer, "db.example.com",
+				v1.LoadBalancerIngress{IP: "2001:db8::1", Hostname: "db.elb.amazonaws.com"}),
+			[]Route{{Subdomain: "db.example.com", Ips: []string{}, UseAlias: true, Alias: "db.elb.amazonaws.com", Resource: "service/default/db"}},
+		},
+		{
+			"ipv6 load balancer only",
+			testService(v1.ServiceTypeLoadBalancer, "db.example.com", v1.LoadBalancerIngress{IP: "2001:db8::1"}),
+			[]Route{},
+		},
+		{
+			"load balancer without an address",
+			testService(v1.ServiceTypeLoadBalancer, "db.example.com"),
+			[]Route{},
+		},
+		{
+			"not a load balancer",
+			testService(v1.ServiceTypeClusterIP, "db.example.com", v1.LoadBalancerIngress{IP: "10.0.0.1"}),
+			[]Route{},
+		},
+		{
+			"without hostnames",
+			testService(v1.ServiceTypeLoadBalancer, "", v1.LoadBalancerIngress{IP: "10.0.0.1"}),
+			[]Route{},
+		},
+	}
+	for _, test := range tests {
+		Setup(zap.NewNop().Sugar())
+		changes := State.UpdateService(test.svc, watch.Added)
+		if !reflect.DeepEqual(changes.Changed, test.want) {
+			t.Errorf("%s: UpdateService() changed %+v, want %+v", test.name, changes.Changed, test.want)
+		}
+		if routes := State.Routes(); len(routes) != len(test.want) {
+			t.Errorf("%s: Routes() = %+v, want %d routes", test.name, routes, len(test.want))
+		}
+	}
+}
+
+func TestServiceLoadBalancerReady(t *testing.T) {
+	Setup(zap.NewNop().Sugar())
+	svc := testService(v1.ServiceTypeLoadBalancer, "db.example.com")
+	if changes := State.UpdateService(svc, watch.Added); len(changes.Changed) != 0 || len(changes.Deleted) != 0 {
+		t.Errorf("UpdateService() without an address = %+v, want no changes", changes)
+	}
+	svc = testService(v1.ServiceTypeLoadBalancer, "db.example.com", v1.LoadBalancerIngress{IP: "10.0.0.1"})
+	changes := State.UpdateService(svc, watch.Modified)
+	want := RouteChanges{
+		Deleted: []Route{},
+		Changed: []Route{{Subdomain: "db.example.com", Ips: []string{"10.0.0.1"}, Resource: "service/default/db"}},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("UpdateService() = %+v, want %+v", changes, want)
+	}
+	changes = State.UpdateService(svc, watch.Deleted)
+	want = RouteChanges{Deleted: want.Changed, Changed: []Route{}}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("UpdateService() = %+v, want %+v", changes, want)
+	}
+}
@@ -0,0 +1,70 @@
+package view
+
+import (
+	"sort"
+
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/pkg/watch"
+
+	"github.com/victor-fdez/kube-route53-traefik/apis/networking"
+	"github.com/victor-fdez/kube-route53-traefik/apis/traefik"
+)
+
+// UpdateIngressRoute routes the hostnames of the Host matchers of a Traefik
+// IngressRoute like the ones of an ingress
+func (c ClusterView) UpdateIngressRoute(route *traefik.IngressRoute, eventType watch.EventType) RouteChanges {
+	lock.Lock()
+	defer lock.Unlock()
+	generation++
+	ingress := createIngressRoute(kindIngressRoute, route.ObjectMeta, route.Spec, route.Hostnames())
+	return c.updateIngress(kindIngressRoute+"/"+route.Namespace+"/"+route.Name, ingress, eventType)
+}
+
+// UpdateIngressRouteTCP routes the hostnames of the HostSNI matchers of a
+// Traefik IngressRouteTCP like the ones of an ingress
+func (c ClusterView) UpdateIngressRouteTCP(route *traefik.IngressRouteTCP, eventType watch.EventType) RouteChanges {
+	lock.Lock()
+	defer lock.Unlock()
+	generation++
+	ingress := createIngressRoute(kindIngressRouteTCP, route.ObjectMeta, route.Spec, route.Hostnames())
+	return c.updateIngress(kindIngressRouteTCP+"/"+route.Namespace+"/"+route.Name, ingress, eventType)
+}
+
+func createIngressRoute(kind string, meta v1.ObjectMeta, spec traefik.IngressRouteSpec, hostnames []string) Ingress {
+	return Ingress{
+		name:      meta.Name,
+		namespace: meta.Namespace,
+		hostnames: hostnames,
+		// Traefik filters the custom resources by class with the same
+		// annotation as ingresses
+		ingCtrlName: meta.Annotations[networking.ClassAnnotation],
+		kind:        kind,
+		entryPoints: spec.EntryPoints,
+	}
+}
+
+// entryPointsCtrl returns the first ingress controller by name exposing
+// one of the entry points, or any entry point when there are none as
+// Traefik then serves the route on every default entry point
+func (c ClusterView) entryPointsCtrl(entryPoints []string) string {
+	names := make([]string, 0, len(c.ingCtrls))
+	for name, ingCtrl := range c.ingCtrls {
+		if len(ingCtrl.EntryPoints) != 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if len(entryPoints) == 0 {
+			return name
+		}
+		for _, exposed := range c.ingCtrls[name].EntryPoints {
+			for _, entryPoint := range entryPoints {
+				if exposed == entryPoint {
+					return name
+				}
+			}
+		}
+	}
+	return ""
+}
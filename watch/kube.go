@@ -2,33 +2,81 @@ package watch
 
 import (
 	"fmt"
-	"os"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/victor-fdez/kube-route53-traefik/apis/gateway"
+	"github.com/victor-fdez/kube-route53-traefik/apis/networking"
+	"github.com/victor-fdez/kube-route53-traefik/apis/traefik"
 	"github.com/victor-fdez/kube-route53-traefik/dns_providers"
+	"github.com/victor-fdez/kube-route53-traefik/leader"
+	"github.com/victor-fdez/kube-route53-traefik/metrics"
 	"github.com/victor-fdez/kube-route53-traefik/view"
 
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/pkg/api/meta"
 	"k8s.io/client-go/pkg/api/v1"
 	v1beta1 "k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/pkg/fields"
+	"k8s.io/client-go/pkg/runtime"
 	"k8s.io/client-go/pkg/watch"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+// event is a change of a watched object delivered by the informers
+type event struct {
+	Type   watch.EventType
+	Object interface{}
+}
+
+// source is a resource listed and watched into the cluster view
+type source struct {
+	resource string
+	lw       cache.ListerWatcher
+	objType  runtime.Object
+}
+
 var client *kubernetes.Clientset
-var ingressWatcher, serviceWatcher, nodeWatcher watch.Interface
-var ingressWatcherDone, serviceWatcherDone, nodeWatcherDone bool
-var dryRun bool
+var sources []source
+var informers []cache.SharedInformer
+var events chan event
+var resyncInterval time.Duration
+var ownerID string
+var leaderElection bool
+
+// ingCtrlNamespace is the namespace of the ingress controller services
+const ingCtrlNamespace = "kube-system"
+
+// stallTimeout is how long the event loop may be busy before the process
+// is reported unhealthy, it only updates the cluster view while the DNS
+// provider is called by the worker
+const stallTimeout = time.Minute
+
+// statusLock protects the status of the event loop read by the health
+// server
+var statusLock sync.Mutex
+var lastLoop time.Time
+var synced bool
 var sLog *zap.SugaredLogger
 
-func Setup(kubeconfig *string, DryRun bool, SLog *zap.SugaredLogger) {
+// Setup creates the informers listing and watching the ingresses (and
+// ingress classes), the Traefik IngressRoutes, the Gateway API gateways
+// and HTTPRoutes, services and nodes. They reconnect on their own and
+// deliver every object again every ResyncInterval when it is not zero.
+// Start reconciles the DNS records with the view once the informers are
+// synced, and then every ResyncInterval. Only the records owned by OwnerID
+// are ever changed.
+func Setup(kubeconfig *string, OwnerID string, ResyncInterval time.Duration, SLog *zap.SugaredLogger) {
 	var err error
 	var config *rest.Config
-	dryRun = DryRun
 	sLog = SLog
-	//var serviceWatcherDone, nodeWatcherDone bool
+	resyncInterval = ResyncInterval
+	ownerID = OwnerID
 	if *kubeconfig != "" {
 		// uses the current context in kubeconfig
 		config, err = clientcmd.BuildConfigFromFlags("", *kubeconfig)
@@ -43,114 +91,431 @@ func Setup(kubeconfig *string, DryRun bool, SLog *zap.SugaredLogger) {
 		}
 	}
 	// creates the clientset
-	client, err := kubernetes.NewForConfig(config)
+	client, err = kubernetes.NewForConfig(config)
 	if err != nil {
 		sLog.Panic(err)
 	}
-	// setup watchers
-	ingressWatcher, err = client.Ingresses("").Watch(v1.ListOptions{})
-	if err != nil {
-		sLog.Panic(err)
+	// setup the cluster view, the DNS provider is setup by the caller
+	view.Setup(sLog)
+	events = make(chan event, 100)
+	sources = ingressSources()
+	sources = append(sources, traefikSources()...)
+	sources = append(sources, gatewaySources()...)
+	sources = append(sources,
+		// the services of every namespace can publish their load balancer,
+		// the ingress controllers are only looked for in kube-system
+		source{
+			resource: "services",
+			lw:       cache.NewListWatchFromClient(client.Core().RESTClient(), "services", v1.NamespaceAll, fields.Everything()),
+			objType:  &v1.Service{},
+		},
+		source{
+			resource: "nodes",
+			lw:       cache.NewListWatchFromClient(client.Core().RESTClient(), "nodes", v1.NamespaceAll, fields.Everything()),
+			objType:  &v1.Node{},
+		})
+	informers = make([]cache.SharedInformer, 0, len(sources))
+	for _, s := range sources {
+		informers = append(informers, newInformer(s.lw, s.objType))
 	}
-	// this service must be located in kube-system as the traefik ha proxy
-	// will work as an ingress controller and it needs to be in this privileged
-	// namespace
-	serviceWatcher, err = client.Services("kube-system").Watch(v1.ListOptions{})
-	if err != nil {
-		sLog.Panic(err)
+}
+
+// ingressSources returns the networking.k8s.io/v1 ingresses and ingress
+// classes when the cluster serves them, and the extensions/v1beta1
+// ingresses otherwise
+func ingressSources() []source {
+	if !serves(networking.GroupVersion, "ingresses") {
+		sLog.Infof("%s is not served, watching extensions/v1beta1 ingresses", networking.GroupVersion)
+		return []source{{
+			resource: "ingresses",
+			lw:       cache.NewListWatchFromClient(client.Extensions().RESTClient(), "ingresses", v1.NamespaceAll, fields.Everything()),
+			objType:  &v1beta1.Ingress{},
+		}}
+	}
+	sources := []source{{
+		resource: "ingresses",
+		lw: newRESTListWatch(client.Core().RESTClient(), "/apis/"+networking.GroupVersion+"/ingresses",
+			func() runtime.Object { return &networking.IngressList{} },
+			func() runtime.Object { return &networking.Ingress{} }),
+		objType: &networking.Ingress{},
+	}}
+	if serves(networking.GroupVersion, "ingressclasses") {
+		sources = append(sources, source{
+			resource: "ingressclasses",
+			lw: newRESTListWatch(client.Core().RESTClient(), "/apis/"+networking.GroupVersion+"/ingressclasses",
+				func() runtime.Object { return &networking.IngressClassList{} },
+				func() runtime.Object { return &networking.IngressClass{} }),
+			objType: &networking.IngressClass{},
+		})
+	}
+	return sources
+}
+
+// traefikSources returns the Traefik IngressRoutes and IngressRouteTCPs
+// when their custom resources are installed
+func traefikSources() []source {
+	for _, groupVersion := range traefik.GroupVersions {
+		if !serves(groupVersion, "ingressroutes") {
+			continue
+		}
+		sLog.Infof("Watching the Traefik IngressRoutes of %s", groupVersion)
+		sources := []source{{
+			resource: "ingressroutes",
+			lw: newRESTListWatch(client.Core().RESTClient(), "/apis/"+groupVersion+"/ingressroutes",
+				func() runtime.Object { return &traefik.IngressRouteList{} },
+				func() runtime.Object { return &traefik.IngressRoute{} }),
+			objType: &traefik.IngressRoute{},
+		}}
+		if serves(groupVersion, "ingressroutetcps") {
+			sources = append(sources, source{
+				resource: "ingressroutetcps",
+				lw: newRESTListWatch(client.Core().RESTClient(), "/apis/"+groupVersion+"/ingressroutetcps",
+					func() runtime.Object { return &traefik.IngressRouteTCPList{} },
+					func() runtime.Object { return &traefik.IngressRouteTCP{} }),
+				objType: &traefik.IngressRouteTCP{},
+			})
+		}
+		return sources
+	}
+	return nil
+}
+
+// gatewaySources returns the Gateway API gateways and HTTPRoutes when
+// their custom resources are installed
+func gatewaySources() []source {
+	for _, groupVersion := range gateway.GroupVersions {
+		if !serves(groupVersion, "gateways") || !serves(groupVersion, "httproutes") {
+			continue
+		}
+		sLog.Infof("Watching the gateways and HTTPRoutes of %s", groupVersion)
+		return []source{{
+			resource: "gateways",
+			lw: newRESTListWatch(client.Core().RESTClient(), "/apis/"+groupVersion+"/gateways",
+				func() runtime.Object { return &gateway.GatewayList{} },
+				func() runtime.Object { return &gateway.Gateway{} }),
+			objType: &gateway.Gateway{},
+		}, {
+			resource: "httproutes",
+			lw: newRESTListWatch(client.Core().RESTClient(), "/apis/"+groupVersion+"/httproutes",
+				func() runtime.Object { return &gateway.HTTPRouteList{} },
+				func() runtime.Object { return &gateway.HTTPRoute{} }),
+			objType: &gateway.HTTPRoute{},
+		}}
 	}
-	nodeWatcher, err = client.Nodes().Watch(v1.ListOptions{})
+	return nil
+}
+
+// serves tells if the cluster serves resource in groupVersion
+func serves(groupVersion, resource string) bool {
+	resources, err := client.Discovery().ServerResourcesForGroupVersion(groupVersion)
 	if err != nil {
-		sLog.Panic(err)
+		return false
 	}
-	// setup the DNS provider, and cluster view
-	dns_providers.Setup(dryRun, sLog)
-	view.Setup(sLog)
-	// setup AWS dns provider
-	ingressWatcherDone = false
-	serviceWatcherDone = false
-	nodeWatcherDone = false
+	for _, r := range resources.APIResources {
+		if r.Name == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// newInformer creates an informer sending the changes of resource to the
+// events channel
+func newInformer(lw cache.ListerWatcher, objType runtime.Object) cache.SharedInformer {
+	informer := cache.NewSharedInformer(lw, objType, resyncInterval)
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			events <- event{Type: watch.Added, Object: obj}
+		},
+		UpdateFunc: func(oldObj, obj interface{}) {
+			events <- event{Type: watch.Modified, Object: obj}
+		},
+		DeleteFunc: func(obj interface{}) {
+			// the last state is unknown when the delete was missed
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			events <- event{Type: watch.Deleted, Object: obj}
+		},
+	})
+	return informer
+}
+
+// EnableLeaderElection makes the replicas sharing the ConfigMap
+// Namespace/Name elect a leader, only the leader changes the DNS records
+// while the others keep their cluster view up to date. Identity must be
+// unique to each replica.
+func EnableLeaderElection(Namespace, Name, Identity string) {
+	leader.Setup(client, Namespace, Name, Identity, sLog)
+	leaderElection = true
 }
 
 //TODO: find the ELB route from service load balancer specified, add an anotation to service
 //TODO: specify either nodeport or service name to use
-//TODO: add service watcher also for this kind of event
 
+// Start runs the informers and processes their events, it never returns
 func Start() {
-	// get watcher for services in kubernetes
-	ingressEventChan := ingressWatcher.ResultChan()
-	serviceEventChan := serviceWatcher.ResultChan()
-	nodeEventChan := nodeWatcher.ResultChan()
+	stop := make(chan struct{})
+	hasSynced := make([]cache.InformerSynced, 0, len(informers))
+	for _, informer := range informers {
+		go informer.Run(stop)
+		hasSynced = append(hasSynced, informer.HasSynced)
+	}
+	go runWorker()
+	syncedChan := make(chan struct{})
+	go func() {
+		if cache.WaitForCacheSync(stop, hasSynced...) {
+			close(syncedChan)
+		}
+	}()
+	// until the informers are synced the events only fill the cluster view,
+	// the first reconciliation then updates every route at once
+	initialSync := true
+	// without leader election we always lead, otherwise the DNS records are
+	// only changed once elected
+	leading := !leaderElection
+	var leaderChan <-chan bool
+	if leaderElection {
+		leaderChan = leader.Run(stop)
+	}
+	var resyncChan <-chan time.Time
+	if resyncInterval > 0 {
+		resyncChan = time.NewTicker(resyncInterval).C
+	}
+	// failed DNS changes are retried with a backoff
+	retryTicker := time.NewTicker(time.Second)
 	for {
+		statusLock.Lock()
+		lastLoop = time.Now()
+		statusLock.Unlock()
 		select {
-		case event, ok := <-ingressEventChan:
-			if ok {
-				// process each event received
-				ingress := event.Object.(*v1beta1.Ingress)
-				sLog.Infof("%s ingress %s/%s with ingress controller [%v]",
-					event.Type,
-					ingress.Namespace,
-					ingress.Name,
-					ingress.Annotations["kubernetes.io/ingress.class"])
-				routeChanges := view.State.UpdateIngress(ingress, event.Type)
-				updateRoutes(routeChanges)
-				view.State.Dump()
+		case <-syncedChan:
+			syncedChan = nil
+			initialSync = false
+			sLog.Infof("Informers synced")
+			if leading {
+				// the worker reports the sync complete once reconciled
+				queueReconcile()
 			} else {
-				// error with channel/or no more events
-				fmt.Printf("Error: no more service events")
-				ingressWatcherDone = true
+				statusLock.Lock()
+				synced = true
+				statusLock.Unlock()
 			}
-		case event, ok := <-serviceEventChan:
-			if ok {
-				// process each event received
-				service := event.Object.(*v1.Service)
-				sLog.Infof("%s service %s with ingresses %v", event.Type, service.Name, service.Status.LoadBalancer.Ingress)
-				routeChanges := view.State.UpdateIngCtrlSvc(service, event.Type)
-				updateRoutes(routeChanges)
-				view.State.Dump()
-			} else {
-				// error with channel/or no more events
-				fmt.Printf("Error: no more service events")
-				serviceWatcherDone = true
+		case leading = <-leaderChan:
+			leadershipChanged(leading, initialSync)
+		case <-retryTicker.C:
+			if leading {
+				queueRetry()
 			}
-		case event, ok := <-nodeEventChan:
-			if ok {
-				node := event.Object.(*v1.Node)
-				sLog.Infof("%s node %s with IP [%v]", event.Type, node.Name, node.Status.Addresses)
-				routeChanges := view.State.UpdateNode(node, event.Type)
-				updateRoutes(routeChanges)
-				view.State.Dump()
-			} else {
-				fmt.Printf("Error: no more node events")
-				nodeWatcherDone = true
+		case <-resyncChan:
+			if leading && !initialSync {
+				queueReconcile()
+			}
+		case e := <-events:
+			routeChanges := handleEvent(e)
+			if leading && !initialSync {
+				queueRouteChanges(routeChanges)
 			}
+			ingresses, nodes, ingCtrls := view.State.Counts()
+			metrics.Ingresses.Set(float64(ingresses))
+			metrics.Nodes.Set(float64(nodes))
+			metrics.IngressControllers.Set(float64(ingCtrls))
+		}
+	}
+}
+
+// Healthy tells if the event loop is running and the informers listed
+// their objects at least once
+func Healthy() error {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+	if lastLoop.IsZero() {
+		return fmt.Errorf("the event loop is not running")
+	}
+	if stalled := time.Since(lastLoop); stalled > stallTimeout {
+		return fmt.Errorf("the event loop is stuck for %v", stalled)
+	}
+	for _, informer := range informers {
+		if informer.LastSyncResourceVersion() == "" {
+			return fmt.Errorf("the watches are not connected yet")
+		}
+	}
+	return nil
+}
+
+// Ready tells if the informers are synced and the DNS records were
+// reconciled with them
+func Ready() error {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+	if !synced {
+		return fmt.Errorf("the initial sync is not complete")
+	}
+	return nil
+}
+
+// handleEvent updates the cluster view with an event
+func handleEvent(e event) view.RouteChanges {
+	switch obj := e.Object.(type) {
+	case *v1beta1.Ingress:
+		return handleEvent(event{Type: e.Type, Object: networking.FromExtensions(obj)})
+	case *networking.Ingress:
+		metrics.WatchEvents.WithLabelValues("ingress", string(e.Type)).Inc()
+		sLog.Infof("%s ingress %s/%s with ingress controller [%v]",
+			e.Type,
+			obj.Namespace,
+			obj.Name,
+			obj.Class())
+		return view.State.UpdateIngress(obj, e.Type)
+	case *networking.IngressClass:
+		metrics.WatchEvents.WithLabelValues("ingressclass", string(e.Type)).Inc()
+		sLog.Infof("%s ingress class %s of controller %s (default %v)",
+			e.Type,
+			obj.Name,
+			obj.Spec.Controller,
+			obj.IsDefault())
+		return view.State.UpdateIngressClass(obj, e.Type)
+	case *traefik.IngressRoute:
+		metrics.WatchEvents.WithLabelValues("ingressroute", string(e.Type)).Inc()
+		sLog.Infof("%s ingress route %s/%s with hostnames %v on entry points %v",
+			e.Type,
+			obj.Namespace,
+			obj.Name,
+			obj.Hostnames(),
+			obj.Spec.EntryPoints)
+		return view.State.UpdateIngressRoute(obj, e.Type)
+	case *traefik.IngressRouteTCP:
+		metrics.WatchEvents.WithLabelValues("ingressroutetcp", string(e.Type)).Inc()
+		sLog.Infof("%s ingress route tcp %s/%s with hostnames %v on entry points %v",
+			e.Type,
+			obj.Namespace,
+			obj.Name,
+			obj.Hostnames(),
+			obj.Spec.EntryPoints)
+		return view.State.UpdateIngressRouteTCP(obj, e.Type)
+	case *gateway.Gateway:
+		metrics.WatchEvents.WithLabelValues("gateway", string(e.Type)).Inc()
+		sLog.Infof("%s gateway %s/%s with IPs %v and hostname [%v]", e.Type, obj.Namespace, obj.Name, obj.IPs(), obj.Hostname())
+		return view.State.UpdateGateway(obj, e.Type)
+	case *gateway.HTTPRoute:
+		metrics.WatchEvents.WithLabelValues("httproute", string(e.Type)).Inc()
+		sLog.Infof("%s http route %s/%s with hostnames %v on gateways %v",
+			e.Type,
+			obj.Namespace,
+			obj.Name,
+			obj.Spec.Hostnames,
+			obj.Gateways())
+		return view.State.UpdateHTTPRoute(obj, e.Type)
+	case *v1.Service:
+		metrics.WatchEvents.WithLabelValues("service", string(e.Type)).Inc()
+		sLog.Infof("%s service %s/%s with ingresses %v", e.Type, obj.Namespace, obj.Name, obj.Status.LoadBalancer.Ingress)
+		routeChanges := view.State.UpdateService(obj, e.Type)
+		// this service must be located in kube-system as the traefik ha proxy
+		// will work as an ingress controller and it needs to be in this privileged
+		// namespace
+		if obj.Namespace == ingCtrlNamespace {
+			ctrlChanges := view.State.UpdateIngCtrlSvc(obj, e.Type)
+			routeChanges.Deleted = append(routeChanges.Deleted, ctrlChanges.Deleted...)
+			routeChanges.Changed = append(routeChanges.Changed, ctrlChanges.Changed...)
+		}
+		return routeChanges
+	case *v1.Node:
+		metrics.WatchEvents.WithLabelValues("node", string(e.Type)).Inc()
+		sLog.Infof("%s node %s with IP [%v]", e.Type, obj.Name, obj.Status.Addresses)
+		return view.State.UpdateNode(obj, e.Type)
+	default:
+		sLog.Warnf("Ignoring %s event of unexpected object %T", e.Type, e.Object)
+		return view.NoRoutes()
+	}
+}
+
+// reconcile makes the DNS records match every route of the cluster view,
+// fixing whatever the events missed
+func reconcile() {
+	desired := desiredRoutes()
+	sLog.Infof("Reconciling %d routes with the DNS provider", len(desired))
+	if _, err := dns_providers.Reconcile(ownerID, desired); err != nil {
+		sLog.Warn(err)
+	}
+}
+
+// desiredRoutes returns every route of the cluster view
+func desiredRoutes() []dns_providers.Route {
+	viewRoutes := view.State.Routes()
+	desired := make([]dns_providers.Route, 0, len(viewRoutes))
+	for _, route := range viewRoutes {
+		options := dns_providers.RouteOptions{
+			Proxied:  route.Proxied,
+			Resource: route.Resource,
+			TTL:      route.TTL,
+			CNAME:    route.CNAME,
 		}
-		// if any or all of the channels are finished then
-		// exit process
-		if nodeWatcherDone ||
-			serviceWatcherDone ||
-			ingressWatcherDone {
-			os.Exit(0)
+		desired = append(desired, dns_providers.NewRoute(route.Subdomain, route.Ips, route.Alias, options))
+	}
+	return desired
+}
+
+// ListOnce lists the ingresses, services and nodes into the cluster view
+// without watching them, for the commands running only once
+func ListOnce() error {
+	for _, s := range sources {
+		list, err := s.lw.List(api.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("Unable to list %s: %v", s.resource, err)
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return fmt.Errorf("Unable to list %s: %v", s.resource, err)
+		}
+		for _, item := range items {
+			handleEvent(event{Type: watch.Added, Object: item})
 		}
 	}
+	return nil
 }
 
+// Plan returns the changes reconciling the DNS records with the cluster
+// view would apply
+func Plan() ([]dns_providers.PlannedChange, error) {
+	return dns_providers.Plan(ownerID, desiredRoutes())
+}
+
+// Sync reconciles the DNS records with the cluster view once, it returns
+// the changes applied
+func Sync() ([]dns_providers.PlannedChange, error) {
+	desired := desiredRoutes()
+	sLog.Infof("Reconciling %d routes with the DNS provider", len(desired))
+	return dns_providers.Reconcile(ownerID, desired)
+}
+
+// updateRoutes sends the route changes of an event to the DNS provider in
+// a single batch per zone
 func updateRoutes(routeChanges view.RouteChanges) error {
-	id := ""
+	if len(routeChanges.Deleted) == 0 && len(routeChanges.Changed) == 0 {
+		sLog.Infof("No changes to routes")
+		return nil
+	}
+	batch := dns_providers.NewBatch(ownerID)
 	for _, route := range routeChanges.Deleted {
-		err := dns_providers.RemoveRoute(&id, &route.Subdomain, route.Alias)
+		err := batch.RemoveRoute(route.Subdomain, route.Alias)
 		if err != nil {
 			sLog.Warn(err)
 		}
 	}
 	for _, route := range routeChanges.Changed {
-		err := dns_providers.AddRoute(&id, &route.Subdomain, route.Ips, route.Alias)
+		options := dns_providers.RouteOptions{
+			Proxied:  route.Proxied,
+			Resource: route.Resource,
+			TTL:      route.TTL,
+			CNAME:    route.CNAME,
+		}
+		err := batch.AddRoute(route.Subdomain, route.Ips, route.Alias, options)
 		if err != nil {
 			sLog.Warn(err)
 		}
 	}
-	if len(routeChanges.Deleted) == 0 && len(routeChanges.Changed) == 0 {
-		sLog.Infof("No changes to routes")
+	if err := batch.Apply(); err != nil {
+		sLog.Warn(err)
 	}
 	return nil
 }
@@ -0,0 +1,87 @@
+package watch
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"k8s.io/client-go/pkg/api"
+	"k8s.io/client-go/pkg/api/unversioned"
+	"k8s.io/client-go/pkg/runtime"
+	"k8s.io/client-go/pkg/watch"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newRESTListWatch lists and watches the resources served at path (i.e.
+// /apis/networking.k8s.io/v1/ingresses) which client-go has no types for,
+// they are decoded from JSON into the objects returned by newList and
+// newObject
+func newRESTListWatch(c rest.Interface, path string, newList, newObject func() runtime.Object) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(options api.ListOptions) (runtime.Object, error) {
+			req := c.Get().AbsPath(path)
+			if options.ResourceVersion != "" {
+				req = req.Param("resourceVersion", options.ResourceVersion)
+			}
+			body, err := req.DoRaw()
+			if err != nil {
+				return nil, err
+			}
+			list := newList()
+			if err := json.Unmarshal(body, list); err != nil {
+				return nil, err
+			}
+			return list, nil
+		},
+		WatchFunc: func(options api.ListOptions) (watch.Interface, error) {
+			req := c.Get().AbsPath(path).Param("watch", "true")
+			if options.ResourceVersion != "" {
+				req = req.Param("resourceVersion", options.ResourceVersion)
+			}
+			if options.TimeoutSeconds != nil {
+				req = req.Param("timeoutSeconds", strconv.FormatInt(*options.TimeoutSeconds, 10))
+			}
+			stream, err := req.Stream()
+			if err != nil {
+				return nil, err
+			}
+			return watch.NewStreamWatcher(&jsonDecoder{
+				stream:    stream,
+				decoder:   json.NewDecoder(stream),
+				newObject: newObject,
+			}), nil
+		},
+	}
+}
+
+// jsonDecoder decodes the events of a watch stream
+type jsonDecoder struct {
+	stream    io.ReadCloser
+	decoder   *json.Decoder
+	newObject func() runtime.Object
+}
+
+func (d *jsonDecoder) Decode() (watch.EventType, runtime.Object, error) {
+	var e struct {
+		Type   watch.EventType `json:"type"`
+		Object json.RawMessage `json:"object"`
+	}
+	if err := d.decoder.Decode(&e); err != nil {
+		return "", nil, err
+	}
+	// errors (i.e. an expired resource version) carry a status, the
+	// informer lists the resources again
+	var obj runtime.Object = &unversioned.Status{}
+	if e.Type != watch.Error {
+		obj = d.newObject()
+	}
+	if err := json.Unmarshal(e.Object, obj); err != nil {
+		return "", nil, err
+	}
+	return e.Type, obj, nil
+}
+
+func (d *jsonDecoder) Close() {
+	d.stream.Close()
+}
@@ -0,0 +1,111 @@
+package watch
+
+import (
+	"sync"
+
+	"github.com/victor-fdez/kube-route53-traefik/dns_providers"
+	"github.com/victor-fdez/kube-route53-traefik/view"
+)
+
+// pendingWork is the DNS work queued by the event loop for the worker, the
+// DNS provider can take minutes under throttling so it is never called
+// from the event loop itself
+type pendingWork struct {
+	sync.Mutex
+	reconcile    bool
+	retry        bool
+	routeChanges []view.RouteChanges
+}
+
+var work pendingWork
+
+// workReady wakes the worker up, it holds at most one signal since the
+// worker takes all the pending work at once
+var workReady = make(chan struct{}, 1)
+
+// queueReconcile asks the worker for a full reconciliation, which
+// supersedes the route changes queued so far
+func queueReconcile() {
+	work.Lock()
+	work.reconcile = true
+	work.routeChanges = nil
+	work.Unlock()
+	wakeWorker()
+}
+
+// queueRouteChanges asks the worker to apply the route changes of an
+// event, after the ones queued before
+func queueRouteChanges(routeChanges view.RouteChanges) {
+	if len(routeChanges.Deleted) == 0 && len(routeChanges.Changed) == 0 {
+		sLog.Infof("No changes to routes")
+		return
+	}
+	work.Lock()
+	if !work.reconcile {
+		work.routeChanges = append(work.routeChanges, routeChanges)
+	}
+	work.Unlock()
+	wakeWorker()
+}
+
+// queueRetry asks the worker to retry the failed DNS changes which are due
+func queueRetry() {
+	work.Lock()
+	work.retry = true
+	work.Unlock()
+	wakeWorker()
+}
+
+// dropWork forgets the pending work once another replica leads
+func dropWork() {
+	work.Lock()
+	work.reconcile = false
+	work.retry = false
+	work.routeChanges = nil
+	work.Unlock()
+}
+
+// leadershipChanged queues a reconciliation once this replica leads, the
+// records may have changed while another replica was leading, and drops
+// the pending work once it no longer does
+func leadershipChanged(leading, initialSync bool) {
+	if leading && !initialSync {
+		queueReconcile()
+	} else if !leading {
+		// the sync is complete for a replica that does not lead
+		dropWork()
+		statusLock.Lock()
+		synced = !initialSync
+		statusLock.Unlock()
+	}
+}
+
+func wakeWorker() {
+	select {
+	case workReady <- struct{}{}:
+	default:
+	}
+}
+
+// runWorker applies the pending DNS work in the order it was queued, it
+// never returns
+func runWorker() {
+	for range workReady {
+		work.Lock()
+		doReconcile, doRetry, routeChanges := work.reconcile, work.retry, work.routeChanges
+		work.reconcile, work.retry, work.routeChanges = false, false, nil
+		work.Unlock()
+		if doReconcile {
+			reconcile()
+			statusLock.Lock()
+			synced = true
+			statusLock.Unlock()
+		}
+		for _, changes := range routeChanges {
+			updateRoutes(changes)
+		}
+		if doRetry {
+			dns_providers.RetryChanges()
+		}
+	}
+}
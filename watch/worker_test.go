@@ -0,0 +1,67 @@
+package watch
+
+import (
+	"testing"
+
+	"github.com/victor-fdez/kube-route53-traefik/view"
+)
+
+// resetWork empties the pending work and the worker signal
+func resetWork() {
+	dropWork()
+	select {
+	case <-workReady:
+	default:
+	}
+}
+
+func routeChanges(subdomain string) view.RouteChanges {
+	return view.RouteChanges{Changed: []view.Route{{Subdomain: subdomain, Ips: []string{"10.0.0.1"}}}}
+}
+
+func TestReconcileSupersedesRouteChanges(t *testing.T) {
+	resetWork()
+	defer resetWork()
+	queueRouteChanges(routeChanges("web.example.com"))
+	queueRouteChanges(routeChanges("api.example.com"))
+	if len(work.routeChanges) != 2 {
+		t.Fatalf("queued route changes %v, want 2", work.routeChanges)
+	}
+	queueReconcile()
+	if !work.reconcile || work.routeChanges != nil {
+		t.Errorf("reconcile %v and route changes %v queued, want only a reconcile", work.reconcile, work.routeChanges)
+	}
+	// the reconcile covers the route changes of the events coming after
+	queueRouteChanges(routeChanges("www.example.com"))
+	if work.routeChanges != nil {
+		t.Errorf("route changes queued after a reconcile: %v", work.routeChanges)
+	}
+	select {
+	case <-workReady:
+	default:
+		t.Error("the worker was not woken up")
+	}
+}
+
+func TestLosingLeadershipDropsWork(t *testing.T) {
+	resetWork()
+	defer resetWork()
+	leadershipChanged(true, false)
+	if !work.reconcile {
+		t.Error("no reconcile queued when leading")
+	}
+	queueRetry()
+	leadershipChanged(false, false)
+	if work.reconcile || work.retry || work.routeChanges != nil {
+		t.Errorf("reconcile %v, retry %v and route changes %v left after losing the leadership",
+			work.reconcile, work.retry, work.routeChanges)
+	}
+	if !synced {
+		t.Error("a replica that does not lead is not synced")
+	}
+	// until the informers synced the reconcile waits for them
+	leadershipChanged(true, true)
+	if work.reconcile {
+		t.Error("reconcile queued before the informers synced")
+	}
+}